@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/openapi"
+)
+
+// generateCommand generates a cassette from the examples of an OpenAPI
+// document.
+var generateCommand = &command{
+	name:    "generate",
+	usage:   "-spec openapi.yaml [-server-url url] -o output.yaml",
+	summary: "generate a cassette from the examples of an OpenAPI document",
+	run:     runGenerate,
+}
+
+func runGenerate(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	spec := fs.String("spec", "", "path to the OpenAPI document in YAML or JSON format")
+	serverURL := fs.String("server-url", "", "create the requests relative to the given URL")
+	output := fs.String("o", "", "path to the output cassette")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 0 || *spec == "" || *output == "" {
+		return errUsage
+	}
+
+	doc, err := openapi.Load(*spec)
+	if err != nil {
+		return err
+	}
+
+	opts := make([]openapi.GenerateOption, 0)
+	if *serverURL != "" {
+		opts = append(opts, openapi.WithServerURL(*serverURL))
+	}
+
+	c, err := doc.Generate(cassetteName(*output), opts...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(c.File); err == nil {
+		return fmt.Errorf("refusing to overwrite existing cassette %s", c.File)
+	}
+
+	if err := c.Save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s: %d interactions\n", c.File, len(c.Interactions))
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/graphql"
+)
+
+// graphqlCommand prints the interactions of a cassette labeled by their
+// GraphQL operations.
+var graphqlCommand = &command{
+	name:    "graphql",
+	usage:   "[-query] input.yaml",
+	summary: "print the GraphQL operations recorded in a cassette",
+	run:     runGraphQL,
+}
+
+func runGraphQL(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	printQuery := fs.Bool("query", false, "print the normalized query documents")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	for _, i := range c.Interactions {
+		requests, err := graphql.Parse(i.Request)
+		if errors.Is(err, graphql.ErrNotGraphQL) {
+			fmt.Fprintf(stdout, "[%d] %s -> %d\n", i.ID, graphql.Label(i), i.Response.Code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		operations := make([]string, 0, len(requests))
+		for _, r := range requests {
+			operation := r.Label()
+			if len(r.Variables) > 0 {
+				variables, err := json.Marshal(r.Variables)
+				if err != nil {
+					return err
+				}
+				operation += " " + string(variables)
+			}
+			operations = append(operations, operation)
+		}
+
+		fmt.Fprintf(stdout, "[%d] %s -> %d", i.ID, strings.Join(operations, ", "), i.Response.Code)
+		switch n := countErrors(i.Response.Body); {
+		case n == 1:
+			fmt.Fprint(stdout, " (1 error)")
+		case n > 1:
+			fmt.Fprintf(stdout, " (%d errors)", n)
+		}
+		fmt.Fprintln(stdout)
+
+		if *printQuery {
+			for _, r := range requests {
+				query, err := graphql.Normalize(r.Query)
+				if err != nil {
+					query = r.Query
+				}
+				fmt.Fprintf(stdout, "    %s\n", query)
+			}
+		}
+	}
+
+	return nil
+}
+
+// countErrors returns the number of GraphQL errors in the given response body,
+// which is a response object, or a batch of response objects.
+func countErrors(body string) int {
+	type response struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+
+	var batch []response
+	if err := json.Unmarshal([]byte(body), &batch); err != nil {
+		var r response
+		if err := json.Unmarshal([]byte(body), &r); err != nil {
+			return 0
+		}
+		batch = append(batch, r)
+	}
+
+	n := 0
+	for _, r := range batch {
+		n += len(r.Errors)
+	}
+
+	return n
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// infoCommand prints the metadata of a cassette.
+var infoCommand = &command{
+	name:    "info",
+	usage:   "input.yaml",
+	summary: "print the metadata of a cassette",
+	run:     runInfo,
+}
+
+func runInfo(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	m := c.Metadata
+	fmt.Fprintf(stdout, "cassette:       %s\n", c.File)
+	fmt.Fprintf(stdout, "version:        %d\n", c.Version)
+	fmt.Fprintf(stdout, "interactions:   %d\n", len(c.Interactions))
+	if !m.RecordedAt.IsZero() {
+		fmt.Fprintf(stdout, "recorded at:    %s\n", m.RecordedAt.Format(time.RFC3339))
+	}
+	if m.RecordedBy != "" {
+		fmt.Fprintf(stdout, "recorded by:    %s\n", m.RecordedBy)
+	}
+	if m.GoVCRVersion != "" {
+		fmt.Fprintf(stdout, "go-vcr version: %s\n", m.GoVCRVersion)
+	}
+	if m.Mode != "" {
+		fmt.Fprintf(stdout, "mode:           %s\n", m.Mode)
+	}
+	if m.Matcher != "" {
+		fmt.Fprintf(stdout, "matcher:        %s\n", m.Matcher)
+	}
+
+	if len(m.Labels) > 0 {
+		keys := make([]string, 0, len(m.Labels))
+		for k := range m.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintln(stdout, "labels:")
+		for _, k := range keys {
+			fmt.Fprintf(stdout, "  %s: %s\n", k, m.Labels[k])
+		}
+	}
+
+	return nil
+}
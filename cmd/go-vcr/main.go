@@ -0,0 +1,108 @@
+// Command go-vcr provides operations for working with go-vcr cassettes.
+//
+// Usage:
+//
+//	go-vcr <command> [arguments]
+//
+// Run "go-vcr help" for the list of supported commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errUsage is returned by commands, which were invoked with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+// command represents a sub-command of the CLI.
+type command struct {
+	// name is the name of the command
+	name string
+
+	// usage describes the arguments of the command
+	usage string
+
+	// summary is a short description of the command
+	summary string
+
+	// run executes the command with the given arguments and writes its
+	// output to the given writer
+	run func(fs *flag.FlagSet, args []string, stdout io.Writer) error
+}
+
+// commands are the supported sub-commands of the CLI
+var commands = []*command{
+	generateCommand,
+	graphqlCommand,
+	infoCommand,
+	mergeCommand,
+	redirectsCommand,
+	splitCommand,
+	validateCommand,
+	verifyCommand,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command specified by the given arguments and returns the
+// exit code of the CLI.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		return 2
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+
+		fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		fs.Usage = func() {
+			fmt.Fprintf(stderr, "usage: go-vcr %s %s\n", cmd.name, cmd.usage)
+			fs.PrintDefaults()
+		}
+
+		err := cmd.run(fs, args[1:], stdout)
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 2
+		case errors.Is(err, errUsage):
+			fs.Usage()
+			return 2
+		default:
+			fmt.Fprintf(stderr, "go-vcr %s: %s\n", cmd.name, err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "go-vcr: unknown command %q\n", args[0])
+	printUsage(stderr)
+
+	return 2
+}
+
+// printUsage prints the usage of the CLI.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: go-vcr <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// cassetteName returns the name of the cassette stored in the given file.
+func cassetteName(path string) string {
+	return strings.TrimSuffix(path, ".yaml")
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// newTestCassette saves a new cassette with GET interactions for the given
+// URLs in a temporary directory.
+func newTestCassette(t *testing.T, dir, name string, urls ...string) string {
+	t.Helper()
+
+	c := cassette.New(filepath.Join(dir, name))
+	for _, u := range urls {
+		c.AddInteraction(&cassette.Interaction{
+			Request: cassette.Request{Method: http.MethodGet, URL: u},
+		})
+	}
+
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	return c.File
+}
+
+func TestMergeAndSplit(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := newTestCassette(t, dir, "first", "http://foo.example.com/a")
+	second := newTestCassette(t, dir, "second", "http://bar.example.com/b", "http://foo.example.com/c")
+	merged := filepath.Join(dir, "merged.yaml")
+
+	var stderr bytes.Buffer
+	if code := run([]string{"merge", "-o", merged, first, second}, io.Discard, &stderr); code != 0 {
+		t.Fatalf("merge failed with exit code %d: %s", code, stderr.String())
+	}
+
+	c, err := cassette.Load(cassetteName(merged))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 3 {
+		t.Fatalf("expected 3 merged interactions, got %d", len(c.Interactions))
+	}
+
+	if code := run([]string{"split", "-by", "host", merged}, io.Discard, &stderr); code != 0 {
+		t.Fatalf("split failed with exit code %d: %s", code, stderr.String())
+	}
+
+	foo, err := cassette.Load(filepath.Join(dir, "merged-foo.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(foo.Interactions) != 2 {
+		t.Fatalf("expected 2 interactions for foo.example.com, got %d", len(foo.Interactions))
+	}
+
+	// Existing cassettes should not be overwritten
+	if code := run([]string{"split", "-by", "host", merged}, io.Discard, &stderr); code != 1 {
+		t.Fatalf("expected split to fail with exit code 1, got %d", code)
+	}
+
+	if code := run([]string{"split", "-by", "path", merged}, io.Discard, &stderr); code != 2 {
+		t.Fatalf("expected split to fail with exit code 2, got %d", code)
+	}
+
+	// The merged cassette should not be overwritten
+	if code := run([]string{"merge", "-o", merged, first}, io.Discard, &stderr); code != 1 {
+		t.Fatalf("expected merge to fail with exit code 1, got %d", code)
+	}
+
+	c, err = cassette.Load(cassetteName(merged))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 3 {
+		t.Fatalf("merged cassette was overwritten with %d interactions", len(c.Interactions))
+	}
+}
+
+func TestInfo(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New(filepath.Join(dir, "info"))
+	c.Metadata.RecordedBy = "tester"
+	c.Metadata.Mode = "record"
+	c.Metadata.Labels = map[string]string{"service": "users"}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"info", c.File}, &stdout, &stderr); code != 0 {
+		t.Fatalf("info failed with exit code %d: %s", code, stderr.String())
+	}
+
+	for _, want := range []string{"recorded by:    tester", "mode:           record", "  service: users"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("info output does not contain %q:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New(filepath.Join(dir, "redirects"))
+	first := &cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "http://example.com/old"},
+		Response: cassette.Response{
+			Code:    http.StatusFound,
+			Headers: http.Header{"Location": {"/new"}},
+		},
+	}
+	c.AddInteraction(first)
+	c.AddInteraction(&cassette.Interaction{
+		ParentID: &first.ID,
+		Request:  cassette.Request{Method: http.MethodGet, URL: "http://example.com/new"},
+		Response: cassette.Response{Code: http.StatusOK},
+	})
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "http://example.com/other"},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"redirects", c.File}, &stdout, &stderr); code != 0 {
+		t.Fatalf("redirects failed with exit code %d: %s", code, stderr.String())
+	}
+
+	want := "[0] GET http://example.com/old -> 302 /new\n  [1] GET http://example.com/new -> 200\n"
+	if stdout.String() != want {
+		t.Fatalf("want output:\n%s\ngot output:\n%s", want, stdout.String())
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "1"}`)
+	}))
+	defer server.Close()
+
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New(filepath.Join(dir, "verify"))
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "http://api.example.com/users/1"},
+		Response: cassette.Response{
+			Code:    http.StatusOK,
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body:    `{"id": 1}`,
+		},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"verify", "-base-url", server.URL, c.File}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+
+	if !strings.Contains(stdout.String(), "schema: $.id: recorded number, got string") {
+		t.Fatalf("unexpected report:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"verify", "-base-url", server.URL, "-schema=false", "-headers", "Content-Type, ", c.File}, &stdout, &stderr); code != 0 {
+		t.Fatalf("verify failed with exit code %d: %s\n%s", code, stderr.String(), stdout.String())
+	}
+
+	// Requests to an unreachable service fail the verification
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	stdout.Reset()
+	if code := run([]string{"verify", "-base-url", closed.URL, c.File}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+
+	if !strings.Contains(stdout.String(), "1 failed") {
+		t.Fatalf("unexpected report:\n%s", stdout.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New(filepath.Join(dir, "validate"))
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "https://api.example.com/v1/users/1"},
+		Response: cassette.Response{
+			Code:    http.StatusOK,
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body:    `{"id": 1, "name": "Ava"}`,
+		},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := "../../pkg/openapi/fixtures/users.yaml"
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"validate", "-spec", spec, c.File}, &stdout, &stderr); code != 0 {
+		t.Fatalf("validate failed with exit code %d: %s\n%s", code, stderr.String(), stdout.String())
+	}
+
+	c.Interactions[0].Response.Body = `{"id": "1"}`
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"validate", "-spec", spec, c.File}, &stdout, &stderr); code != 1 {
+		t.Fatalf("expected exit code 1, got %d: %s", code, stderr.String())
+	}
+
+	for _, want := range []string{
+		`response.body: missing required property "name"`,
+		"response.body.id: expected integer, got string",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Fatalf("expected %q in report:\n%s", want, stdout.String())
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "generated.yaml")
+	spec := "../../pkg/openapi/fixtures/users.yaml"
+	var stdout, stderr bytes.Buffer
+	args := []string{"generate", "-spec", spec, "-server-url", "http://localhost:8080/v1", "-o", output}
+	if code := run(args, &stdout, &stderr); code != 0 {
+		t.Fatalf("generate failed with exit code %d: %s", code, stderr.String())
+	}
+
+	if stdout.String() != output+": 7 interactions\n" {
+		t.Fatalf("unexpected output: %s", stdout.String())
+	}
+
+	c, err := cassette.Load(cassetteName(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Interactions[0].Request.URL != "http://localhost:8080/v1/users" {
+		t.Fatalf("unexpected request URL %s", c.Interactions[0].Request.URL)
+	}
+
+	// The generated cassette should not be overwritten
+	if code := run(args, io.Discard, &stderr); code != 1 {
+		t.Fatalf("expected generate to fail with exit code 1, got %d", code)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New(filepath.Join(dir, "graphql"))
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{
+			Method:  http.MethodPost,
+			URL:     "http://example.com/graphql",
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body:    `{"query": "query GetUser($id: ID!) { user(id: $id) { name, id } }", "variables": {"id": "1"}}`,
+		},
+		Response: cassette.Response{Code: http.StatusOK, Body: `{"data": {"user": null}, "errors": [{"message": "not found"}]}`},
+	})
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{
+			Method: http.MethodGet,
+			URL:    "http://example.com/graphql?query=%7B+users+%7B+id+%7D+%7D",
+		},
+		Response: cassette.Response{Code: http.StatusOK},
+	})
+	c.AddInteraction(&cassette.Interaction{
+		Request:  cassette.Request{Method: http.MethodGet, URL: "http://example.com/health"},
+		Response: cassette.Response{Code: http.StatusOK},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"graphql", "-query", c.File}, &stdout, &stderr); code != 0 {
+		t.Fatalf("graphql failed with exit code %d: %s", code, stderr.String())
+	}
+
+	want := `[0] query GetUser {"id":"1"} -> 200 (1 error)
+    query GetUser($id:ID!){user(id:$id){id name}}
+[1] query -> 200
+    query{users{id}}
+[2] GET http://example.com/health -> 200
+`
+	if stdout.String() != want {
+		t.Fatalf("want output:\n%s\ngot output:\n%s", want, stdout.String())
+	}
+}
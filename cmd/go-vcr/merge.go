@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// mergeCommand merges multiple cassettes into a single one.
+var mergeCommand = &command{
+	name:    "merge",
+	usage:   "-o output.yaml input.yaml...",
+	summary: "merge cassettes into a single cassette",
+	run:     runMerge,
+}
+
+// splitCommand splits a cassette into multiple cassettes.
+var splitCommand = &command{
+	name:    "split",
+	usage:   "[-by host|path|test|header] [-prefix /a,/b] [-header name] input.yaml",
+	summary: "split a cassette into multiple cassettes",
+	run:     runSplit,
+}
+
+func runMerge(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	output := fs.String("o", "", "path of the merged cassette")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" || fs.NArg() == 0 {
+		return errUsage
+	}
+
+	dst := cassette.New(cassetteName(*output))
+	if _, err := os.Stat(dst.File); err == nil {
+		return fmt.Errorf("refusing to overwrite existing cassette %s", dst.File)
+	}
+
+	srcs := make([]*cassette.Cassette, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		src, err := cassette.Load(cassetteName(path))
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, src)
+	}
+
+	cassette.Merge(dst, srcs...)
+	if err := dst.Save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s: %d interactions\n", dst.File, len(dst.Interactions))
+
+	return nil
+}
+
+func runSplit(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	by := fs.String("by", "host", "split interactions by host, path, test or header")
+	prefixes := fs.String("prefix", "", "comma-separated path prefixes used when splitting by path")
+	header := fs.String("header", "", "request header used when splitting by header")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	var fn cassette.SplitFunc
+	switch *by {
+	case "host":
+		fn = cassette.SplitByHost
+	case "path":
+		if *prefixes == "" {
+			return errUsage
+		}
+		fn = cassette.SplitByPathPrefix(strings.Split(*prefixes, ",")...)
+	case "test":
+		fn = cassette.SplitByTest
+	case "header":
+		if *header == "" {
+			return errUsage
+		}
+		fn = cassette.SplitByHeader(*header)
+	default:
+		return errUsage
+	}
+
+	src, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	result, err := cassette.Split(src, fn)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		c := result[key]
+		if _, err := os.Stat(c.File); err == nil {
+			return fmt.Errorf("refusing to overwrite existing cassette %s", c.File)
+		}
+	}
+
+	for _, key := range keys {
+		c := result[key]
+		if err := c.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: %d interactions\n", c.File, len(c.Interactions))
+	}
+
+	return nil
+}
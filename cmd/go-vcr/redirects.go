@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// redirectsCommand prints the redirect chains recorded in a cassette.
+var redirectsCommand = &command{
+	name:    "redirects",
+	usage:   "input.yaml",
+	summary: "print the redirect chains recorded in a cassette",
+	run:     runRedirects,
+}
+
+func runRedirects(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	for n, chain := range c.RedirectChains() {
+		if n > 0 {
+			fmt.Fprintln(stdout)
+		}
+
+		for depth, i := range chain {
+			fmt.Fprintf(stdout, "%s[%d] %s %s -> %d",
+				strings.Repeat("  ", depth), i.ID, i.Request.Method, i.Request.URL, i.Response.Code)
+			if location := i.Response.Headers.Get("Location"); location != "" {
+				fmt.Fprintf(stdout, " %s", location)
+			}
+			fmt.Fprintln(stdout)
+		}
+	}
+
+	return nil
+}
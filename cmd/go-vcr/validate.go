@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/openapi"
+)
+
+// errInvalid is returned by the validate command, when interactions do not
+// conform to the OpenAPI document.
+var errInvalid = errors.New("invalid interactions")
+
+// validateCommand validates the interactions of a cassette against an OpenAPI
+// document.
+var validateCommand = &command{
+	name:    "validate",
+	usage:   "-spec openapi.yaml input.yaml",
+	summary: "validate a cassette against an OpenAPI document",
+	run:     runValidate,
+}
+
+func runValidate(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	spec := fs.String("spec", "", "path to the OpenAPI document in YAML or JSON format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 || *spec == "" {
+		return errUsage
+	}
+
+	doc, err := openapi.Load(*spec)
+	if err != nil {
+		return err
+	}
+
+	c, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	report := doc.Validate(c)
+	if _, err := report.WriteTo(stdout); err != nil {
+		return err
+	}
+
+	if !report.Valid() {
+		return errInvalid
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/url"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/drift"
+)
+
+// errDrift is returned by the verify command, when drift was detected.
+var errDrift = errors.New("drift detected")
+
+// errVerifyFailed is returned by the verify command, when any of the
+// interactions could not be verified.
+var errVerifyFailed = errors.New("verification failed")
+
+// verifyCommand re-issues the recorded requests of a cassette and reports the
+// drift of the live responses.
+var verifyCommand = &command{
+	name:    "verify",
+	usage:   "[-base-url url] [-unsafe] [-headers a,b] [-status=false] [-schema=false] input.yaml",
+	summary: "detect drift between a cassette and the live service",
+	run:     runVerify,
+}
+
+func runVerify(fs *flag.FlagSet, args []string, stdout io.Writer) error {
+	baseURL := fs.String("base-url", "", "issue the requests against the given base URL")
+	unsafe := fs.Bool("unsafe", false, "issue requests using unsafe methods, e.g. POST or DELETE")
+	headers := fs.String("headers", "Content-Type", "comma-separated response headers, which must be present")
+	status := fs.Bool("status", true, "compare the status codes")
+	schema := fs.Bool("schema", true, "compare the schema of JSON bodies")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	c, err := cassette.Load(cassetteName(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+
+	required := make([]string, 0)
+	if *headers != "" {
+		for _, name := range strings.Split(*headers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				required = append(required, name)
+			}
+		}
+	}
+
+	opts := []drift.Option{
+		drift.WithUnsafeMethods(*unsafe),
+		drift.WithRequiredHeaders(required...),
+		drift.WithStatus(*status),
+		drift.WithSchema(*schema),
+	}
+
+	if *baseURL != "" {
+		u, err := url.Parse(*baseURL)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, drift.WithBaseURL(u))
+	}
+
+	report, err := drift.Verify(context.Background(), c, opts...)
+	if err != nil {
+		return err
+	}
+
+	if _, err := report.WriteTo(stdout); err != nil {
+		return err
+	}
+
+	if report.Failed() {
+		return errVerifyFailed
+	}
+
+	if report.HasDrift() {
+		return errDrift
+	}
+
+	return nil
+}
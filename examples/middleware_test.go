@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
 	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
@@ -21,12 +22,13 @@ func TestMiddleware(t *testing.T) {
 		rec, err := recorder.New(
 			cassetteName,
 			recorder.WithMode(recorder.ModeRecordOnly),
-			// Use a BeforeSaveHook to remove host, remote_addr, and duration
-			// since they change whenever the test runs
+			// Use a BeforeSaveHook to remove host, remote_addr, duration and
+			// recorded_at since they change whenever the test runs
 			recorder.WithHook(func(i *cassette.Interaction) error {
 				i.Request.Host = ""
 				i.Request.RemoteAddr = ""
 				i.Response.Duration = 0
+				i.RecordedAt = time.Time{}
 				return nil
 			}, recorder.BeforeSaveHook),
 		)
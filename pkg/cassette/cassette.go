@@ -26,6 +26,7 @@ package cassette
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -44,6 +45,10 @@ import (
 const (
 	// CassetteFormatVersion is the supported cassette version.
 	CassetteFormatVersion = 2
+
+	// RepeatUnlimited is the value of [Interaction.Repeat] for interactions,
+	// which may be replayed any number of times.
+	RepeatUnlimited = -1
 )
 
 var (
@@ -57,6 +62,11 @@ var (
 	// ErrUnsupportedCassetteFormat is returned when attempting to use an
 	// older and potentially unsupported format of a cassette.
 	ErrUnsupportedCassetteFormat = fmt.Errorf("unsupported cassette version format")
+
+	// ErrInteractionOutOfOrder indicates that a request matched a recorded
+	// interaction, which is not the next one in the recorded sequence, when
+	// strict ordering is enabled.
+	ErrInteractionOutOfOrder = errors.New("requested interaction is out of order")
 )
 
 // Request represents a client request as recorded in the cassette file.
@@ -111,6 +121,35 @@ type Response struct {
 
 	// Response duration
 	Duration time.Duration `yaml:"duration"`
+
+	// Timing is the breakdown of the time it took to complete the request
+	Timing Timing `yaml:"timing,omitempty"`
+
+	// TLS is the TLS connection state of the response, if the connection
+	// used TLS
+	TLS *TLS `yaml:"tls,omitempty"`
+}
+
+// Timing represents a breakdown of the time it took to complete a request, as
+// captured using [net/http/httptrace]. Phases, which did not happen, e.g. when
+// reusing a connection, are zero.
+type Timing struct {
+	// DNSLookup is the time it took to resolve the host
+	DNSLookup time.Duration `yaml:"dns_lookup,omitempty"`
+
+	// Connect is the time it took to establish the connection
+	Connect time.Duration `yaml:"connect,omitempty"`
+
+	// TLSHandshake is the time it took to complete the TLS handshake
+	TLSHandshake time.Duration `yaml:"tls_handshake,omitempty"`
+
+	// FirstByte is the time from the start of the request until the
+	// first byte of the response was received
+	FirstByte time.Duration `yaml:"first_byte,omitempty"`
+
+	// BodyTransfer is the time it took to receive the response body after
+	// the first byte of the response
+	BodyTransfer time.Duration `yaml:"body_transfer,omitempty"`
 }
 
 // Interaction type contains a pair of request/response for a single HTTP
@@ -119,11 +158,47 @@ type Interaction struct {
 	// ID is the id of the interaction
 	ID int `yaml:"id"`
 
+	// ParentID is the id of the interaction, whose redirect response
+	// caused the request of this interaction to be made. It is nil for
+	// requests, which are not part of a redirect chain.
+	ParentID *int `yaml:"parent_id,omitempty"`
+
+	// Test is the name of the test, which recorded the interaction. It is
+	// not considered when matching requests, and is used to split a shared
+	// cassette by test using [SplitByTest].
+	Test string `yaml:"test,omitempty"`
+
 	// Request is the recorded request
 	Request Request `yaml:"request"`
 
 	// Response is the recorded response
-	Response Response `yaml:"response"`
+	Response Response `yaml:"response,omitempty"`
+
+	// Sequence contains the responses, which are returned on consecutive
+	// replays of the interaction, when the same request yields different
+	// responses over time. When set, the Response of the interaction is
+	// the first response from the sequence, which is not saved separately
+	// in the cassette file, and the interactions returned by
+	// [Cassette.GetInteraction] are copies, which have the replayed response
+	// from the sequence as their Response.
+	Sequence []Response `yaml:"sequence,omitempty"`
+
+	// AfterSequence specifies what happens once all responses from the
+	// Sequence have been replayed.
+	AfterSequence SequenceBehavior `yaml:"after_sequence,omitempty"`
+
+	// RecordedAt is the time when the interaction was recorded
+	RecordedAt time.Time `yaml:"recorded_at,omitempty"`
+
+	// Repeat is the number of times the interaction may be replayed. Zero
+	// means the interaction is replayed once, and [RepeatUnlimited] means
+	// that it may be replayed any number of times.
+	Repeat int `yaml:"repeat,omitempty"`
+
+	// Template specifies whether the body and header values of the
+	// response are templates, which are expanded using the live request
+	// when replaying the interaction. See [Interaction.RenderHTTPResponse].
+	Template bool `yaml:"template,omitempty"`
 
 	// DiscardOnSave if set to true will discard the interaction as a whole
 	// and it will not be part of the final interactions when saving the
@@ -132,6 +207,21 @@ type Interaction struct {
 
 	// replayed is true when this interaction has been played already.
 	replayed bool `yaml:"-"`
+
+	// replays is the number of times this interaction has been played.
+	replays int `yaml:"-"`
+
+	// captured is true when this interaction was added to the cassette
+	// during the current session, instead of being loaded from disk.
+	captured bool `yaml:"-"`
+
+	// source is the interaction from the cassette, when this interaction
+	// is a copy returned for a replay of its sequence of responses.
+	source *Interaction `yaml:"-"`
+
+	// position is the index of the replayed response in the sequence of
+	// the source interaction.
+	position int `yaml:"-"`
 }
 
 // WasReplayed returns a boolean indicating whether the given interaction was
@@ -140,6 +230,90 @@ func (i *Interaction) WasReplayed() bool {
 	return i.replayed
 }
 
+// MarshalYAML implements the [yaml.Marshaler] interface. The Response of an
+// interaction with a sequence of responses is omitted, since it is the first
+// response from the sequence.
+func (i Interaction) MarshalYAML() (any, error) {
+	// The plain type has no methods, so that it is marshaled as usual
+	type plain Interaction
+	if len(i.Sequence) > 0 {
+		i.Response = Response{}
+	}
+
+	return plain(i), nil
+}
+
+// markReplayed marks the interaction as replayed, and returns the interaction
+// to replay. For interactions with a sequence of responses, it is a copy of the
+// interaction with the next response from the sequence, so that the interaction
+// from the cassette is never modified while being replayed.
+func (i *Interaction) markReplayed() *Interaction {
+	replay := i
+	if len(i.Sequence) > 0 {
+		clone := *i
+		clone.position = i.sequenceIndex()
+		clone.Response = i.Sequence[clone.position]
+		clone.source = i
+		replay = &clone
+	}
+	i.replayed = true
+	i.replays += 1
+
+	return replay
+}
+
+// expectedReplays returns the number of times the interaction is expected to be
+// replayed according to the recording.
+func (i *Interaction) expectedReplays() int {
+	if len(i.Sequence) > 0 {
+		return len(i.Sequence)
+	}
+
+	return max(i.Repeat, 1)
+}
+
+// exhausted returns true, if the interaction has been replayed as many times as
+// it may be replayed.
+func (i *Interaction) exhausted() bool {
+	if len(i.Sequence) > 0 {
+		return !i.AfterSequence.unlimited() && i.replays >= len(i.Sequence)
+	}
+
+	if i.Repeat == RepeatUnlimited {
+		return false
+	}
+
+	return i.replays >= i.expectedReplays()
+}
+
+// VolatileHeaders are the response headers, which are ignored when comparing
+// interactions for deduplication, since they usually differ between otherwise
+// identical responses.
+var VolatileHeaders = []string{"Date", "Age", "Expires", "X-Request-Id"}
+
+// sameAs returns true, if the given interaction has the same request and
+// response, ignoring the time it took to complete it, and the given response
+// headers.
+func (i *Interaction) sameAs(other *Interaction, ignoreHeaders []string) bool {
+	if len(i.Sequence) > 0 || len(other.Sequence) > 0 {
+		return false
+	}
+
+	resp, otherResp := i.Response, other.Response
+	resp.Duration, otherResp.Duration = 0, 0
+	resp.Timing, otherResp.Timing = Timing{}, Timing{}
+
+	if len(ignoreHeaders) > 0 {
+		resp.Headers, otherResp.Headers = resp.Headers.Clone(), otherResp.Headers.Clone()
+		for _, name := range ignoreHeaders {
+			resp.Headers.Del(name)
+			otherResp.Headers.Del(name)
+		}
+	}
+
+	return reflect.DeepEqual(i.Request, other.Request) && reflect.DeepEqual(resp, otherResp)
+}
+
 // GetHTTPRequest converts the recorded interaction request to http.Request
 // instance.
 func (i *Interaction) GetHTTPRequest() (*http.Request, error) {
@@ -176,6 +350,14 @@ func (i *Interaction) GetHTTPResponse() (*http.Response, error) {
 		return nil, err
 	}
 
+	var tlsState *tls.ConnectionState
+	if i.Response.TLS != nil {
+		tlsState, err = i.Response.TLS.ConnectionState()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	resp := &http.Response{
 		Status:           i.Response.Status,
 		StatusCode:       i.Response.Code,
@@ -190,6 +372,7 @@ func (i *Interaction) GetHTTPResponse() (*http.Response, error) {
 		Header:           i.Response.Headers,
 		Close:            true,
 		Request:          req,
+		TLS:              tlsState,
 	}
 
 	return resp, nil
@@ -205,6 +388,27 @@ type defaultMatcher struct {
 	// If set, the default matcher will ignore matching on any of the
 	// defined headers.
 	ignoreHeaders []string
+
+	// If set, the default matcher will ignore matching on any of the
+	// defined URL query parameters.
+	ignoreQueryParams []string
+
+	// If set, the default matcher will compare the Cookie HTTP header by
+	// cookie names and values.
+	cookieMatching bool
+
+	// If set, the default matcher will ignore matching on any of the
+	// defined cookies.
+	ignoreCookies map[string]bool
+
+	// If set, the default matcher will ignore matching on the values of
+	// any of the defined cookies.
+	ignoreCookieValues map[string]bool
+
+	// If set, the default matcher will ignore the scheme and host of the
+	// URL, the Host, the remote address and the request URI, which vary
+	// between runs for incoming requests of a server.
+	serverSide bool
 }
 
 // DefaultMatcherOption is a function which configures the default matcher.
@@ -240,6 +444,19 @@ func WithIgnoreHeaders(val ...string) DefaultMatcherOption {
 	return opt
 }
 
+// WithIgnoreQueryParams is a [DefaultMatcherOption], which configures the
+// default matcher to ignore matching on the defined URL query parameters. The
+// parameters are ignored in the Referer HTTP header as well, so that the
+// following hops of a redirect chain match, when the URL of the initial request
+// differs only in ignored parameters.
+func WithIgnoreQueryParams(val ...string) DefaultMatcherOption {
+	opt := func(m *defaultMatcher) {
+		m.ignoreQueryParams = append(m.ignoreQueryParams, val...)
+	}
+
+	return opt
+}
+
 // NewDefaultMatcher returns the default matcher.
 func NewDefaultMatcher(opts ...DefaultMatcherOption) MatcherFunc {
 	m := &defaultMatcher{}
@@ -291,6 +508,26 @@ func (m *defaultMatcher) bodyMatches(r *http.Request, i Request) bool {
 	return true
 }
 
+// stripQueryParams removes the ignored query parameters from the given URL.
+func (m *defaultMatcher) stripQueryParams(rawURL string) string {
+	if len(m.ignoreQueryParams) == 0 {
+		return rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for _, param := range m.ignoreQueryParams {
+		query.Del(param)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
 // matcher is a predicate which matches the provided HTTP request again a
 // recorded interaction request.
 func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
@@ -298,7 +535,11 @@ func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
 		return false
 	}
 
-	if r.URL.String() != i.URL {
+	if m.serverSide {
+		if !m.pathMatches(r.URL, i.URL) {
+			return false
+		}
+	} else if m.stripQueryParams(r.URL.String()) != m.stripQueryParams(i.URL) {
 		return false
 	}
 
@@ -322,6 +563,22 @@ func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
 		delete(cassetteRequestHeaders, header)
 	}
 
+	if m.cookieMatching {
+		if !m.cookiesMatch(requestHeader, cassetteRequestHeaders) {
+			return false
+		}
+		delete(requestHeader, "Cookie")
+		delete(cassetteRequestHeaders, "Cookie")
+	}
+
+	if len(m.ignoreQueryParams) > 0 {
+		for _, header := range []http.Header{requestHeader, cassetteRequestHeaders} {
+			if referer := header.Get("Referer"); referer != "" {
+				header.Set("Referer", m.stripQueryParams(referer))
+			}
+		}
+	}
+
 	if !m.deepEqualContents(requestHeader, cassetteRequestHeaders) {
 		return false
 	}
@@ -338,7 +595,7 @@ func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
 		return false
 	}
 
-	if r.Host != i.Host {
+	if r.Host != i.Host && !m.serverSide {
 		return false
 	}
 
@@ -357,6 +614,10 @@ func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
 		return false
 	}
 
+	if m.serverSide {
+		return true
+	}
+
 	if r.RemoteAddr != i.RemoteAddr {
 		return false
 	}
@@ -368,10 +629,73 @@ func (m *defaultMatcher) matcher(r *http.Request, i Request) bool {
 	return true
 }
 
+// pathMatches is a predicate which tests whether the path and the query of the
+// given URLs match.
+func (m *defaultMatcher) pathMatches(actual *url.URL, recorded string) bool {
+	u, err := url.Parse(recorded)
+	if err != nil {
+		return false
+	}
+
+	x := url.URL{Path: actual.Path, RawPath: actual.RawPath, RawQuery: actual.RawQuery}
+	y := url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery}
+
+	return m.stripQueryParams(x.String()) == m.stripQueryParams(y.String())
+}
+
 // DefaultMatcher is the default matcher used to match HTTP requests with
 // recorded interactions
 var DefaultMatcher = NewDefaultMatcher()
 
+// NewServerMatcher returns a matcher for incoming requests of a server, e.g.
+// for replaying interactions recorded by the HTTP middleware of the recorder.
+// It matches requests like the default matcher configured using the given
+// options, except that the scheme and host of the URL, the Host, the remote
+// address and the request URI are ignored, since they vary between runs.
+func NewServerMatcher(opts ...DefaultMatcherOption) MatcherFunc {
+	m := &defaultMatcher{serverSide: true}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m.matcher
+}
+
+// ServerMatcher is the matcher for incoming requests of a server with the
+// default options.
+var ServerMatcher = NewServerMatcher()
+
+// OrderingGroupFunc returns the name of the group, within which interactions
+// are consumed in their recorded sequence, when strict ordering is enabled.
+type OrderingGroupFunc func(r *http.Request) string
+
+// GroupByHost is an [OrderingGroupFunc], which orders interactions per host.
+func GroupByHost(r *http.Request) string {
+	return r.URL.Host
+}
+
+// Metadata describes when, by whom and how a cassette was recorded.
+type Metadata struct {
+	// RecordedAt is the time when interactions were last recorded into
+	// the cassette
+	RecordedAt time.Time `yaml:"recorded_at,omitempty"`
+
+	// RecordedBy is the name of the user, who recorded the cassette
+	RecordedBy string `yaml:"recorded_by,omitempty"`
+
+	// GoVCRVersion is the version of go-vcr used to record the cassette
+	GoVCRVersion string `yaml:"go_vcr_version,omitempty"`
+
+	// Mode is the mode of the recorder, which recorded the cassette
+	Mode string `yaml:"mode,omitempty"`
+
+	// Matcher describes the matcher used by the recorder
+	Matcher string `yaml:"matcher,omitempty"`
+
+	// Labels are free-form user labels
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
 // Cassette represents a cassette containing recorded interactions.
 type Cassette struct {
 	sync.Mutex `yaml:"-"`
@@ -385,6 +709,9 @@ type Cassette struct {
 	// Cassette format version
 	Version int `yaml:"version"`
 
+	// Metadata describes how the cassette was recorded
+	Metadata Metadata `yaml:"metadata,omitempty"`
+
 	// Interactions between client and server
 	Interactions []*Interaction `yaml:"interactions"`
 
@@ -395,6 +722,19 @@ type Cassette struct {
 	// Matches actual request with interaction requests.
 	Matcher MatcherFunc `yaml:"-"`
 
+	// StrictOrdering defines whether interactions must be consumed in the
+	// sequence in which they were recorded.
+	StrictOrdering bool `yaml:"-"`
+
+	// OrderingGroup splits the interactions into groups, which are ordered
+	// independently of each other, when strict ordering is enabled. If not
+	// set, all interactions are ordered globally.
+	OrderingGroup OrderingGroupFunc `yaml:"-"`
+
+	// orderingGroups caches the ordering group of each interaction, so that
+	// the recorded requests are not rebuilt on every lookup.
+	orderingGroups map[*Interaction]string `yaml:"-"`
+
 	// IsNew specifies whether this is a newly created cassette.
 	// Returns false, when the cassette was loaded from an
 	// existing source, e.g. a file.
@@ -437,25 +777,165 @@ func Load(name string) (*Cassette, error) {
 	}
 	c.nextInteractionId = len(c.Interactions)
 
+	// The response of interactions with a sequence of responses is the
+	// first one from the sequence, which is not saved separately
+	for _, i := range c.Interactions {
+		if len(i.Sequence) > 0 {
+			i.Response = i.Sequence[0]
+		}
+	}
+
 	return c, err
 }
 
+// Contains returns true, if the given interaction is part of the cassette. The
+// copies returned by [Cassette.GetInteraction] for replays of a sequence of
+// responses are part of the cassette as well.
+func (c *Cassette) Contains(i *Interaction) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if i.source != nil {
+		i = i.source
+	}
+
+	for _, existing := range c.Interactions {
+		if existing == i {
+			return true
+		}
+	}
+
+	return false
+}
+
 // AddInteraction appends a new interaction to the cassette
 func (c *Cassette) AddInteraction(i *Interaction) {
 	c.Lock()
 	defer c.Unlock()
 	i.ID = c.nextInteractionId
+	i.captured = true
 	c.nextInteractionId += 1
 	c.Interactions = append(c.Interactions, i)
 }
 
+// ReplaceInteraction replaces an existing interaction from the cassette with a
+// new one, while keeping its position, ID, and the number of times it may be
+// replayed, so that it keeps its place in the recorded sequence when strict
+// ordering is enabled. When the old interaction has a sequence of responses,
+// the response of the new interaction replaces the replayed response in the
+// sequence. The new interaction is appended to the cassette, if the old one is
+// not part of it.
+func (c *Cassette) ReplaceInteraction(old, i *Interaction) {
+	c.Lock()
+	defer c.Unlock()
+
+	position := 0
+	if old != nil && old.source != nil {
+		position = old.position
+		old = old.source
+	}
+
+	for idx, existing := range c.Interactions {
+		if existing != old {
+			continue
+		}
+
+		// The new interaction takes the place of the old one in the
+		// recorded sequence
+		i.ID = old.ID
+		i.captured = old.captured
+		i.replayed = old.replayed
+		i.replays = old.replays
+		if i.Repeat == 0 {
+			i.Repeat = old.Repeat
+		}
+
+		replacement := i
+		if len(old.Sequence) > 0 {
+			// The replayed response of the new interaction is kept,
+			// while the cassette gets the updated sequence
+			merged := *i
+			merged.Sequence = append([]Response(nil), old.Sequence...)
+			merged.Sequence[position] = i.Response
+			merged.Response = merged.Sequence[0]
+			merged.AfterSequence = old.AfterSequence
+			replacement = &merged
+			i.source = replacement
+			i.position = position
+		}
+		c.Interactions[idx] = replacement
+
+		return
+	}
+
+	i.ID = c.nextInteractionId
+	i.captured = true
+	c.nextInteractionId += 1
+	c.Interactions = append(c.Interactions, i)
+}
+
+// Deduplicate collapses consecutive interactions with identical request and
+// response into the first one of them, and marks the rest with DiscardOnSave.
+// The Repeat field of the remaining interaction is set to the number of
+// collapsed interactions, or to [RepeatUnlimited], if unlimited is true.
+// Interactions without duplicates are left unchanged.
+// Identical interactions, which are not consecutive, are kept, so that the
+// order of the interactions is preserved. The [VolatileHeaders] and the given
+// response headers are ignored when comparing the interactions.
+func (c *Cassette) Deduplicate(unlimited bool, ignoreHeaders ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	ignoreHeaders = append(append([]string{}, VolatileHeaders...), ignoreHeaders...)
+
+	var first *Interaction
+	for _, i := range c.Interactions {
+		if i.DiscardOnSave {
+			continue
+		}
+
+		if first == nil || !first.sameAs(i, ignoreHeaders) {
+			first = i
+			continue
+		}
+
+		i.DiscardOnSave = true
+		switch {
+		case unlimited:
+			first.Repeat = RepeatUnlimited
+		case first.Repeat != RepeatUnlimited:
+			first.Repeat = max(first.Repeat, 1) + max(i.Repeat, 1)
+		}
+	}
+}
+
+// UnusedInteractions returns the interactions from the cassette, which were
+// never replayed. Interactions added during the current session via
+// [Cassette.AddInteraction] are not considered unused.
+func (c *Cassette) UnusedInteractions() []*Interaction {
+	c.Lock()
+	defer c.Unlock()
+
+	unused := make([]*Interaction, 0)
+	for _, i := range c.Interactions {
+		if !i.replayed && !i.captured {
+			unused = append(unused, i)
+		}
+	}
+
+	return unused
+}
+
 // GetInteraction retrieves a recorded request/response interaction
 func (c *Cassette) GetInteraction(r *http.Request) (*Interaction, error) {
 	return c.getInteraction(r)
 }
 
 // getInteraction searches for the interaction corresponding to the given HTTP
-// request, by using the configured [MatcherFunc].
+// request, by using the configured [MatcherFunc]. Interactions, which have been
+// replayed as many times as recorded, but may still be replayed again, e.g.
+// ones with [RepeatUnlimited], are used only when no other interaction
+// matches, so that they don't hide later interactions for the same request.
 func (c *Cassette) getInteraction(r *http.Request) (*Interaction, error) {
 	c.Lock()
 	defer c.Unlock()
@@ -464,16 +944,126 @@ func (c *Cassette) getInteraction(r *http.Request) (*Interaction, error) {
 		// r.ParseForm returns missing form body error
 		r.Body = http.NoBody
 	}
+	if c.StrictOrdering {
+		return c.getOrderedInteraction(r)
+	}
+
+	var fallback *Interaction
 	for _, i := range c.Interactions {
-		if (c.ReplayableInteractions || !i.replayed) && c.Matcher(r, i.Request) {
-			i.replayed = true
-			return i, nil
+		if !c.ReplayableInteractions && i.exhausted() {
+			continue
+		}
+
+		if !c.Matcher(r, i.Request) {
+			continue
 		}
+
+		if c.ReplayableInteractions || i.replays < i.expectedReplays() {
+			return i.markReplayed(), nil
+		}
+
+		if fallback == nil {
+			fallback = i
+		}
+	}
+
+	if fallback != nil {
+		return fallback.markReplayed(), nil
 	}
 
 	return nil, ErrInteractionNotFound
 }
 
+// getOrderedInteraction searches for the interaction corresponding to the
+// given HTTP request, while making sure that interactions within the same
+// ordering group are consumed in their recorded sequence.
+func (c *Cassette) getOrderedInteraction(r *http.Request) (*Interaction, error) {
+	group := c.orderingGroupOf(r)
+	var expected *Interaction
+	for _, i := range c.Interactions {
+		// Interactions captured during this session are not part of
+		// the recorded sequence
+		if i.captured {
+			continue
+		}
+
+		interactionGroup, err := c.interactionGroupOf(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if interactionGroup != group {
+			continue
+		}
+
+		// Interactions are no longer expected once they've been
+		// replayed as many times as recorded, but some of them may
+		// still be replayed again
+		if i.replays >= i.expectedReplays() {
+			if (c.ReplayableInteractions || !i.exhausted()) && c.Matcher(r, i.Request) {
+				return i.markReplayed(), nil
+			}
+			continue
+		}
+
+		if expected == nil {
+			expected = i
+			if c.Matcher(r, i.Request) {
+				return i.markReplayed(), nil
+			}
+			continue
+		}
+
+		if c.Matcher(r, i.Request) {
+			return nil, fmt.Errorf(
+				"%w: got %s %s, expected next interaction [%d] %s %s",
+				ErrInteractionOutOfOrder,
+				r.Method,
+				r.URL.String(),
+				expected.ID,
+				expected.Request.Method,
+				expected.Request.URL,
+			)
+		}
+	}
+
+	return nil, ErrInteractionNotFound
+}
+
+// orderingGroupOf returns the ordering group of the given HTTP request.
+func (c *Cassette) orderingGroupOf(r *http.Request) string {
+	if c.OrderingGroup == nil {
+		return ""
+	}
+
+	return c.OrderingGroup(r)
+}
+
+// interactionGroupOf returns the ordering group of the recorded request of the
+// given interaction.
+func (c *Cassette) interactionGroupOf(i *Interaction) (string, error) {
+	if c.OrderingGroup == nil {
+		return "", nil
+	}
+
+	if group, ok := c.orderingGroups[i]; ok {
+		return group, nil
+	}
+
+	ir, err := i.GetHTTPRequest()
+	if err != nil {
+		return "", err
+	}
+
+	if c.orderingGroups == nil {
+		c.orderingGroups = make(map[*Interaction]string)
+	}
+	group := c.OrderingGroup(ir)
+	c.orderingGroups[i] = group
+
+	return group, nil
+}
+
 // Save writes the cassette data on disk for future re-use
 func (c *Cassette) Save() error {
 	c.Lock()
@@ -490,16 +1080,7 @@ func (c *Cassette) Save() error {
 	// Filter out interactions which should be discarded. While discarding
 	// interactions we should also fix the interaction IDs, so that we don't
 	// introduce gaps in the final results.
-	nextId := 0
-	interactions := make([]*Interaction, 0)
-	for _, i := range c.Interactions {
-		if !i.DiscardOnSave {
-			i.ID = nextId
-			interactions = append(interactions, i)
-			nextId += 1
-		}
-	}
-	c.Interactions = interactions
+	c.setInteractions(c.Interactions, linkParents(c.Interactions))
 
 	// Marshal to YAML and save interactions
 	data, err := yaml.Marshal(c)
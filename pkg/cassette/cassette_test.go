@@ -25,12 +25,18 @@
 package cassette
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func getMatcherRequests(t *testing.T) (*http.Request, Request) {
@@ -292,4 +298,887 @@ func TestMatcher(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("CookieMatching", func(t *testing.T) {
+		matcherFn := NewDefaultMatcher(WithIgnoreCookies("tracking"), WithIgnoreCookieValues("session"))
+
+		t.Run("match", func(t *testing.T) {
+			r, i := getMatcherRequests(t)
+
+			r.Header = http.Header{
+				"Cookie": {"theme=dark; session=abc; tracking=1"},
+			}
+
+			i.Headers = http.Header{
+				"Cookie": {"session=xyz; theme=dark"},
+			}
+
+			if b := matcherFn(r, i); !b {
+				t.Fatalf("request should have matched")
+			}
+		})
+
+		t.Run("missing cookie", func(t *testing.T) {
+			r, i := getMatcherRequests(t)
+
+			r.Header = http.Header{
+				"Cookie": {"theme=dark"},
+			}
+
+			i.Headers = http.Header{
+				"Cookie": {"session=xyz; theme=dark"},
+			}
+
+			if b := matcherFn(r, i); b {
+				t.Fatalf("request should not have matched")
+			}
+		})
+
+		t.Run("different value", func(t *testing.T) {
+			r, i := getMatcherRequests(t)
+
+			r.Header = http.Header{
+				"Cookie": {"session=abc; theme=light"},
+			}
+
+			i.Headers = http.Header{
+				"Cookie": {"session=xyz; theme=dark"},
+			}
+
+			if b := matcherFn(r, i); b {
+				t.Fatalf("request should not have matched")
+			}
+		})
+	})
+}
+
+func TestStrictOrdering(t *testing.T) {
+	newCassette := func() *Cassette {
+		c := New("strict-ordering")
+		c.StrictOrdering = true
+		c.Interactions = []*Interaction{
+			{ID: 0, Request: Request{Method: http.MethodPost, URL: "http://foo.example.com/items"}},
+			{ID: 1, Request: Request{Method: http.MethodDelete, URL: "http://foo.example.com/items/1"}},
+			{ID: 2, Request: Request{Method: http.MethodGet, URL: "http://bar.example.com/status"}},
+		}
+		c.Matcher = func(r *http.Request, i Request) bool {
+			return r.Method == i.Method && r.URL.String() == i.URL
+		}
+
+		return c
+	}
+
+	newRequest := func(method, urlStr string) *http.Request {
+		r, err := http.NewRequest(method, urlStr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("in order", func(t *testing.T) {
+		c := newCassette()
+		for _, i := range c.Interactions {
+			got, err := c.GetInteraction(newRequest(i.Request.Method, i.Request.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != i.ID {
+				t.Fatalf("expected interaction %d, got %d", i.ID, got.ID)
+			}
+		}
+	})
+
+	t.Run("out of order", func(t *testing.T) {
+		c := newCassette()
+		_, err := c.GetInteraction(newRequest(http.MethodDelete, "http://foo.example.com/items/1"))
+		if !errors.Is(err, ErrInteractionOutOfOrder) {
+			t.Fatalf("expected ErrInteractionOutOfOrder, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "[0] POST http://foo.example.com/items") {
+			t.Fatalf("error does not name the expected interaction: %s", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		c := newCassette()
+		_, err := c.GetInteraction(newRequest(http.MethodGet, "http://foo.example.com/unknown"))
+		if err != ErrInteractionNotFound {
+			t.Fatalf("expected ErrInteractionNotFound, got %v", err)
+		}
+	})
+
+	t.Run("group by host", func(t *testing.T) {
+		c := newCassette()
+		c.OrderingGroup = GroupByHost
+
+		if _, err := c.GetInteraction(newRequest(http.MethodGet, "http://bar.example.com/status")); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := c.GetInteraction(newRequest(http.MethodDelete, "http://foo.example.com/items/1"))
+		if !errors.Is(err, ErrInteractionOutOfOrder) {
+			t.Fatalf("expected ErrInteractionOutOfOrder, got %v", err)
+		}
+	})
+}
+
+func TestMergeAndSplit(t *testing.T) {
+	newCassette := func(name string, urls ...string) *Cassette {
+		c := New(name)
+		for _, u := range urls {
+			c.AddInteraction(&Interaction{Request: Request{Method: http.MethodGet, URL: u}})
+		}
+		return c
+	}
+
+	first := newCassette("first", "http://foo.example.com/api/users", "http://bar.example.com/status")
+	second := newCassette("second", "http://foo.example.com/api/items", "http://foo.example.com/health")
+	second.Interactions[0].DiscardOnSave = true
+
+	dst := newCassette("merged", "http://bar.example.com/api/items")
+	Merge(dst, first, second)
+
+	wantURLs := []string{
+		"http://bar.example.com/api/items",
+		"http://foo.example.com/api/users",
+		"http://bar.example.com/status",
+		"http://foo.example.com/health",
+	}
+	if len(dst.Interactions) != len(wantURLs) {
+		t.Fatalf("expected %d merged interactions, got %d", len(wantURLs), len(dst.Interactions))
+	}
+	for idx, i := range dst.Interactions {
+		if i.ID != idx {
+			t.Fatalf("expected interaction ID %d, got %d", idx, i.ID)
+		}
+		if i.Request.URL != wantURLs[idx] {
+			t.Fatalf("expected interaction URL %s, got %s", wantURLs[idx], i.Request.URL)
+		}
+	}
+
+	// Sources should not be modified
+	if first.Interactions[1].ID != 1 {
+		t.Fatalf("source interaction was modified")
+	}
+
+	t.Run("by host", func(t *testing.T) {
+		result, err := Split(dst, SplitByHost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 2 {
+			t.Fatalf("expected 2 cassettes, got %d", len(result))
+		}
+
+		foo := result["foo.example.com"]
+		if foo == nil || foo.Name != "merged-foo.example.com" {
+			t.Fatalf("unexpected cassette for foo.example.com: %v", foo)
+		}
+		if len(foo.Interactions) != 2 || foo.Interactions[1].ID != 1 {
+			t.Fatalf("unexpected interactions for foo.example.com")
+		}
+
+		// New interactions should continue the sequence
+		i := &Interaction{}
+		foo.AddInteraction(i)
+		if i.ID != 2 {
+			t.Fatalf("expected new interaction ID 2, got %d", i.ID)
+		}
+	})
+
+	t.Run("by path prefix", func(t *testing.T) {
+		result, err := Split(dst, SplitByPathPrefix("/api/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != 2 {
+			t.Fatalf("expected 2 cassettes, got %d", len(result))
+		}
+
+		if n := len(result["/api/"].Interactions); n != 2 {
+			t.Fatalf("expected 2 interactions with prefix, got %d", n)
+		}
+
+		other := result[""]
+		if other.Name != "merged-default" || len(other.Interactions) != 2 {
+			t.Fatalf("unexpected cassette for interactions without prefix")
+		}
+	})
+
+	t.Run("by test", func(t *testing.T) {
+		c := newCassette("tests", "http://example.com/a", "http://example.com/b", "http://example.com/c")
+		for idx, name := range []string{"TestA", "TestB/sub", "TestA"} {
+			c.Interactions[idx].Test = name
+		}
+
+		result, err := Split(c, SplitByTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result) != 2 || len(result["TestA"].Interactions) != 2 || result["TestB/sub"].Name != "tests-TestB_sub" {
+			t.Fatalf("unexpected split by test: %v", result)
+		}
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		c := newCassette("collision", "http://a:1/", "http://a_1/")
+		if _, err := Split(c, SplitByHost); !errors.Is(err, ErrSplitCollision) {
+			t.Fatalf("expected ErrSplitCollision, got %v", err)
+		}
+	})
+}
+
+func TestSequenceBehavior(t *testing.T) {
+	newCassette := func(after SequenceBehavior) *Cassette {
+		c := New("sequence-behavior")
+		c.Interactions = []*Interaction{
+			{
+				Request: Request{Method: http.MethodGet, URL: "http://example.com/status"},
+				Sequence: []Response{
+					{Code: http.StatusAccepted, Body: "pending"},
+					{Code: http.StatusOK, Body: "done"},
+				},
+				AfterSequence: after,
+			},
+		}
+		c.Matcher = func(r *http.Request, i Request) bool {
+			return r.Method == i.Method && r.URL.String() == i.URL
+		}
+		return c
+	}
+
+	replay := func(t *testing.T, c *Cassette, times int) []string {
+		bodies := make([]string, 0)
+		for n := 0; n < times; n++ {
+			r, err := http.NewRequest(http.MethodGet, "http://example.com/status", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			i, err := c.GetInteraction(r)
+			if err == ErrInteractionNotFound {
+				bodies = append(bodies, "not found")
+				continue
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			bodies = append(bodies, i.Response.Body)
+		}
+		return bodies
+	}
+
+	tests := map[SequenceBehavior]string{
+		SequenceError:      "pending done not found not found",
+		SequenceRepeatLast: "pending done done done",
+		SequenceCycle:      "pending done pending done",
+	}
+
+	for after, want := range tests {
+		t.Run(string(after), func(t *testing.T) {
+			got := strings.Join(replay(t, newCassette(after), 4), " ")
+			if got != want {
+				t.Fatalf("got responses %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReplaceInteraction(t *testing.T) {
+	c := New("replace-interaction")
+	c.Matcher = func(r *http.Request, i Request) bool {
+		return r.Method == i.Method && r.URL.String() == i.URL
+	}
+	c.AddInteraction(&Interaction{
+		Request: Request{Method: http.MethodGet, URL: "http://example.com/status"},
+		Sequence: []Response{
+			{Code: http.StatusAccepted, Body: "pending"},
+			{Code: http.StatusOK, Body: "done"},
+		},
+		AfterSequence: SequenceRepeatLast,
+	})
+	c.AddInteraction(&Interaction{
+		Request:  Request{Method: http.MethodGet, URL: "http://example.com/users"},
+		Response: Response{Code: http.StatusOK, Body: "old"},
+		Repeat:   3,
+	})
+
+	get := func(url string) *Interaction {
+		r, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		i, err := c.GetInteraction(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return i
+	}
+
+	// Replace the second response of the sequence
+	get("http://example.com/status")
+	old := get("http://example.com/status")
+	c.ReplaceInteraction(old, &Interaction{
+		Request:  old.Request,
+		Response: Response{Code: http.StatusOK, Body: "finished"},
+	})
+
+	i := c.Interactions[0]
+	if i.ID != 0 || len(i.Sequence) != 2 || i.Sequence[0].Body != "pending" || i.Sequence[1].Body != "finished" {
+		t.Fatalf("unexpected sequence %+v", i.Sequence)
+	}
+
+	if i.AfterSequence != SequenceRepeatLast || i.Response.Body != "pending" {
+		t.Fatalf("unexpected interaction %+v", i)
+	}
+
+	old = get("http://example.com/users")
+	c.ReplaceInteraction(old, &Interaction{
+		Request:  old.Request,
+		Response: Response{Code: http.StatusOK, Body: "new"},
+	})
+
+	if i := c.Interactions[1]; i.ID != 1 || i.Repeat != 3 || i.Response.Body != "new" {
+		t.Fatalf("unexpected interaction %+v", i)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	c := New("deduplicate")
+	for n, path := range []string{"/a", "/a", "/a", "/b", "/a"} {
+		c.AddInteraction(&Interaction{
+			Request: Request{Method: http.MethodGet, URL: "http://example.com" + path},
+			Response: Response{
+				Code: http.StatusOK,
+				Headers: http.Header{
+					"Date":    {fmt.Sprintf("Sun, 18 Oct 2026 13:00:0%d GMT", n)},
+					"X-Trace": {fmt.Sprint(n)},
+				},
+			},
+		})
+	}
+
+	c.Deduplicate(false, "X-Trace")
+
+	got := make([]string, 0)
+	for _, i := range c.Interactions {
+		if !i.DiscardOnSave {
+			got = append(got, fmt.Sprintf("%s x%d", strings.TrimPrefix(i.Request.URL, "http://example.com"), max(i.Repeat, 1)))
+		}
+	}
+
+	if want := "/a x3, /b x1, /a x1"; strings.Join(got, ", ") != want {
+		t.Fatalf("want %s, got %s", want, strings.Join(got, ", "))
+	}
+}
+
+func TestDeduplicateUnlimited(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "deduplicate-unlimited"))
+	for _, item := range []struct{ method, body string }{
+		{http.MethodGet, "items=0"},
+		{http.MethodGet, "items=0"},
+		{http.MethodPost, "created"},
+		{http.MethodGet, "items=1"},
+	} {
+		c.AddInteraction(&Interaction{
+			Request:  Request{Method: item.method, URL: "http://example.com/items"},
+			Response: Response{Code: http.StatusOK, Body: item.body},
+		})
+	}
+	c.Deduplicate(true)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Matcher = func(r *http.Request, i Request) bool {
+		return r.Method == i.Method && r.URL.String() == i.URL
+	}
+
+	// Only the collapsed interactions may be replayed any number of times
+	if c.Interactions[0].Repeat != RepeatUnlimited || c.Interactions[2].Repeat != 0 {
+		t.Fatalf("unexpected repeat %d and %d", c.Interactions[0].Repeat, c.Interactions[2].Repeat)
+	}
+
+	// The unlimited interaction should not hide the later interaction for
+	// the same request
+	got := make([]string, 0)
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodGet, http.MethodGet} {
+		r, err := http.NewRequest(method, "http://example.com/items", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		i, err := c.GetInteraction(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, i.Response.Body)
+	}
+
+	if want := "items=0, created, items=1, items=0"; strings.Join(got, ", ") != want {
+		t.Fatalf("want %s, got %s", want, strings.Join(got, ", "))
+	}
+}
+
+func TestSequenceSave(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "sequence-save"))
+	c.AddInteraction(&Interaction{
+		Request:  Request{Method: http.MethodGet, URL: "http://example.com/status"},
+		Response: Response{Code: http.StatusAccepted, Body: "pending"},
+		Sequence: []Response{
+			{Code: http.StatusAccepted, Body: "pending"},
+			{Code: http.StatusOK, Body: "done"},
+		},
+	})
+
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first response is saved only as part of the sequence
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), "response:") || strings.Count(string(data), "pending") != 1 {
+		t.Fatalf("unexpected cassette file:\n%s", data)
+	}
+
+	// The response is taken from the edited sequence on load
+	data = []byte(strings.Replace(string(data), "pending", "queued", 1))
+	if err := os.WriteFile(c.File, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := c.Interactions[0].Response.Body; got != "queued" {
+		t.Fatalf("want response body %q, got %q", "queued", got)
+	}
+}
+
+func TestSequenceConcurrentReplay(t *testing.T) {
+	c := New("sequence-concurrent")
+	c.Interactions = []*Interaction{
+		{
+			Request:  Request{Method: http.MethodGet, URL: "http://example.com/status"},
+			Response: Response{Code: http.StatusAccepted, Body: "pending"},
+			Sequence: []Response{
+				{Code: http.StatusAccepted, Body: "pending"},
+				{Code: http.StatusOK, Body: "done"},
+			},
+			AfterSequence: SequenceCycle,
+		},
+	}
+	c.Matcher = func(r *http.Request, i Request) bool {
+		return r.Method == i.Method && r.URL.String() == i.URL
+	}
+
+	// Each replay gets its own response, while the interaction from the
+	// cassette is left unchanged
+	var wg sync.WaitGroup
+	for n := 0; n < 10; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r, err := http.NewRequest(http.MethodGet, "http://example.com/status", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			i, err := c.GetInteraction(r)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			resp, err := i.GetHTTPResponse()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if c.Interactions[0].Response.Body != "pending" {
+		t.Fatalf("unexpected response %q", c.Interactions[0].Response.Body)
+	}
+}
+
+func TestGroupSequences(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "group-sequences"))
+	for _, item := range []struct{ path, body string }{
+		{"/status", "pending"},
+		{"/status", "done"},
+		{"/other", "other"},
+		{"/status", "gone"},
+	} {
+		c.AddInteraction(&Interaction{
+			Request:  Request{Method: http.MethodGet, URL: "http://example.com" + item.path},
+			Response: Response{Code: http.StatusOK, Body: item.body},
+		})
+	}
+
+	// Only consecutive interactions are grouped, so that the order of the
+	// interactions is kept
+	c.GroupSequences(SequenceError)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0)
+	for _, i := range c.Interactions {
+		bodies := make([]string, 0)
+		for _, resp := range i.Sequence {
+			bodies = append(bodies, resp.Body)
+		}
+		got = append(got, fmt.Sprintf("%s %s [%s]", i.Request.URL, i.Response.Body, strings.Join(bodies, " ")))
+	}
+
+	want := []string{
+		"http://example.com/status pending [pending done]",
+		"http://example.com/other other []",
+		"http://example.com/status gone []",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("want interactions:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRenderHTTPResponse(t *testing.T) {
+	i := &Interaction{
+		Request: Request{Method: http.MethodPost, URL: "http://example.com/items"},
+		Response: Response{
+			Code:          http.StatusCreated,
+			Body:          `{"id":{{ json "item.id" | toJSON }},"tags":{{ json "item.tags" }},"q":"{{ query "q" }}","year":{{ now.Year }}}`,
+			ContentLength: 1,
+			Headers: http.Header{
+				"Idempotency-Key": {`{{ header "Idempotency-Key" }}`},
+				"Content-Length":  {"1"},
+			},
+		},
+		Template: true,
+	}
+
+	body := `{"item":{"id":"abc \"123\"","tags":["a","b"]}}`
+	r, err := http.NewRequest(http.MethodPost, "http://example.com/items?q=foo", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Idempotency-Key", "key-42")
+
+	resp, err := i.RenderHTTPResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantBody := fmt.Sprintf(`{"id":"abc \"123\"","tags":["a","b"],"q":"foo","year":%d}`, time.Now().Year())
+	if string(data) != wantBody {
+		t.Fatalf("got body %s, want body %s", data, wantBody)
+	}
+
+	if resp.ContentLength != int64(len(wantBody)) {
+		t.Fatalf("got content length %d, want %d", resp.ContentLength, len(wantBody))
+	}
+
+	if got := resp.Header.Get("Idempotency-Key"); got != "key-42" {
+		t.Fatalf("got header value %q, want %q", got, "key-42")
+	}
+
+	// The recorded response should not be modified
+	if i.Response.Headers.Get("Idempotency-Key") != `{{ header "Idempotency-Key" }}` {
+		t.Fatalf("recorded response was modified")
+	}
+
+	// The live request body should still be readable
+	reqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reqBody) != body {
+		t.Fatalf("request body was consumed")
+	}
+
+	// Interactions which are not templates are returned as is
+	i.Template = false
+	resp, err = i.RenderHTTPResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != i.Response.Body {
+		t.Fatalf("non-template response was modified")
+	}
+}
+
+func TestRedirectChainRenumbering(t *testing.T) {
+	dir := t.TempDir()
+	c := New(filepath.Join(dir, "redirects"))
+
+	discarded := &Interaction{DiscardOnSave: true}
+	parent := &Interaction{Request: Request{URL: "http://example.com/old"}}
+	c.AddInteraction(discarded)
+	c.AddInteraction(parent)
+	c.AddInteraction(&Interaction{
+		ParentID: &parent.ID,
+		Request:  Request{URL: "http://example.com/new"},
+	})
+
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := loaded.Interactions[1]
+	if child.ParentID == nil || *child.ParentID != 0 {
+		t.Fatalf("expected the parent id to be renumbered to 0, got %v", child.ParentID)
+	}
+
+	chain := loaded.RedirectChain(child)
+	if len(chain) != 2 || chain[0] != loaded.Interactions[0] {
+		t.Fatalf("unexpected redirect chain: %v", chain)
+	}
+}
+
+func TestServerReplayOptions(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "server-replay"))
+	for _, path := range []string{"/users/1", "/health"} {
+		c.AddInteraction(&Interaction{
+			Request: Request{Method: http.MethodGet, URL: "http://go-vcr" + path},
+			Response: Response{
+				Code: http.StatusOK,
+				Body: `{"id": 1, "name": "Ava"}`,
+				Headers: http.Header{
+					"Content-Type": {"application/json"},
+					"Date":         {"Mon, 02 Jan 2006 15:04:05 GMT"},
+					"X-Request-Id": {"abc"},
+				},
+			},
+		})
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Date", time.Now().Format(http.TimeFormat))
+		w.Header().Set("X-Request-Id", "def")
+		fmt.Fprint(w, `{"name":"Ava","id":1}`)
+	})
+
+	t.Run("compare", func(t *testing.T) {
+		TestServerReplay(t, c.Name, handler,
+			WithReplayIgnoreHeaders("Date", "X-Request-Id"),
+			WithReplayJSONBody(),
+		)
+	})
+
+	t.Run("filter and assert", func(t *testing.T) {
+		var replayed []string
+		TestServerReplay(t, c.Name, handler,
+			WithReplayFilter(func(i *Interaction) bool {
+				return strings.HasSuffix(i.Request.URL, "/health")
+			}),
+			WithReplayAssertFunc(func(t *testing.T, expected *Interaction, actual *httptest.ResponseRecorder) {
+				replayed = append(replayed, expected.Request.URL)
+			}),
+		)
+
+		if len(replayed) != 1 || replayed[0] != "http://go-vcr/health" {
+			t.Fatalf("unexpected replayed interactions: %v", replayed)
+		}
+	})
+}
+
+func TestServerReplayCapture(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "server-replay-capture"))
+	c.AddInteraction(&Interaction{
+		Request: Request{
+			Method:        http.MethodPost,
+			URL:           "http://go-vcr/users",
+			Body:          `{"name": "Ava"}`,
+			ContentLength: 15,
+		},
+		Response: Response{
+			Code: http.StatusCreated,
+			Body: `{"id": "1"}`,
+			Headers: http.Header{
+				"Content-Type": {"application/json"},
+				"Location":     {"/users/1"},
+			},
+		},
+	})
+	c.AddInteraction(&Interaction{
+		Request: Request{Method: http.MethodGet, URL: "http://go-vcr/users/1"},
+		Response: Response{
+			Code:    http.StatusOK,
+			Body:    `{"id": "1", "name": "Ava"}`,
+			Headers: http.Header{"Content-Type": {"application/json"}},
+		},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler starts with fresh state, where the ids differ from the
+	// recorded ones
+	users := make(map[string]string)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodPost:
+			id := fmt.Sprintf("%d", len(users)+10)
+			users[id] = "Ava"
+			w.Header().Set("Location", "/users/"+id)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id": "%s"}`, id)
+		case http.MethodGet:
+			id := strings.TrimPrefix(r.URL.Path, "/users/")
+			name, ok := users[id]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"id": "%s", "name": "%s"}`, id, name)
+		}
+	})
+
+	TestServerReplay(t, c.Name, handler, WithReplayCapture(CaptureJSONPath("id"), CaptureHeader("Location")))
+}
+
+func TestReplayStateApply(t *testing.T) {
+	recorded := &Interaction{
+		Response: Response{
+			Body:    `{"id": 1, "parent": {"id": "2"}}`,
+			Headers: http.Header{"Location": {"/users/1"}},
+		},
+	}
+
+	state := &replayState{}
+	state.capture(
+		[]Capture{CaptureJSONPath("id"), CaptureJSONPath("parent.id"), CaptureHeader("Location")},
+		recorded,
+		http.Header{"Location": {"/users/2"}},
+		`{"id": 2, "parent": {"id": "3"}}`,
+	)
+
+	i := state.apply(&Interaction{
+		Request: Request{
+			URL:     "http://go-vcr/users/1/items?id=1&page=1&parent=2",
+			Body:    `{"id": 1, "parent": "2", "qty": 1, "items": [{"id": "2"}]}`,
+			Headers: http.Header{"Referer": {"http://go-vcr/users/1"}, "Content-Length": {"1"}},
+			Form:    url.Values{"id": {"1"}, "qty": {"1"}},
+		},
+		Response: Response{
+			Body:    `{"id":1,"name":"1"}`,
+			Headers: http.Header{"Location": {"/users/1"}},
+		},
+	})
+
+	// The substitutions are not chained, i.e. 1 is replaced with 2, but
+	// not with 3, and values of other fields are kept
+	tests := []struct {
+		name, got, want string
+	}{
+		{"url", i.Request.URL, "http://go-vcr/users/2/items?id=2&page=1&parent=2"},
+		{"request body", i.Request.Body, `{"id": 2, "parent": "2", "qty": 1, "items": [{"id": "3"}]}`},
+		{"referer", i.Request.Headers.Get("Referer"), "http://go-vcr/users/2"},
+		{"content length header", i.Request.Headers.Get("Content-Length"), "1"},
+		{"form", i.Request.Form.Encode(), "id=2&qty=1"},
+		{"response body", i.Response.Body, `{"id":2,"name":"1"}`},
+		{"location", i.Response.Headers.Get("Location"), "/users/2"},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: want %q, got %q", test.name, test.want, test.got)
+		}
+	}
+}
+
+func TestServerReplayUpdate(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "server-replay-update"))
+	c.AddInteraction(&Interaction{
+		Request: Request{Method: http.MethodGet, URL: "http://go-vcr/greeting"},
+		Response: Response{
+			Code: http.StatusOK,
+			Body: "Hello",
+		},
+	})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "Hello, World")
+	})
+
+	t.Run("option", func(t *testing.T) {
+		TestServerReplay(t, c.Name, handler, WithReplayUpdate(true))
+	})
+
+	updated, err := Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := updated.Interactions[0].Response
+	if resp.Code != http.StatusAccepted || resp.Body != "Hello, World" || resp.Headers.Get("Content-Type") != "text/plain" {
+		t.Fatalf("response was not updated: %+v", resp)
+	}
+
+	// The updated cassette matches the handler
+	t.Run("replay", func(t *testing.T) {
+		TestServerReplay(t, c.Name, handler)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv(ReplayUpdateEnv, "true")
+		TestServerReplay(t, c.Name, http.NotFoundHandler())
+	})
+
+	updated, err = Load(c.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := updated.Interactions[0].Response.Code; code != http.StatusNotFound {
+		t.Fatalf("want status code %d, got %d", http.StatusNotFound, code)
+	}
 }
@@ -0,0 +1,84 @@
+package cassette
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+)
+
+// WithCookieMatching is a [DefaultMatcherOption], which configures the default
+// matcher to compare the Cookie HTTP header by cookie names and values,
+// regardless of the order of the cookies.
+func WithCookieMatching() DefaultMatcherOption {
+	opt := func(m *defaultMatcher) {
+		m.cookieMatching = true
+	}
+
+	return opt
+}
+
+// WithIgnoreCookies is a [DefaultMatcherOption], which configures the default
+// matcher to compare cookies by name, while ignoring the defined cookies
+// altogether.
+func WithIgnoreCookies(names ...string) DefaultMatcherOption {
+	opt := func(m *defaultMatcher) {
+		m.cookieMatching = true
+		if m.ignoreCookies == nil {
+			m.ignoreCookies = make(map[string]bool)
+		}
+		for _, name := range names {
+			m.ignoreCookies[name] = true
+		}
+	}
+
+	return opt
+}
+
+// WithIgnoreCookieValues is a [DefaultMatcherOption], which configures the
+// default matcher to compare cookies by name, while ignoring the values of the
+// defined cookies. The cookies must still be present in both requests, which is
+// useful for session cookies, which change between recordings.
+func WithIgnoreCookieValues(names ...string) DefaultMatcherOption {
+	opt := func(m *defaultMatcher) {
+		m.cookieMatching = true
+		if m.ignoreCookieValues == nil {
+			m.ignoreCookieValues = make(map[string]bool)
+		}
+		for _, name := range names {
+			m.ignoreCookieValues[name] = true
+		}
+	}
+
+	return opt
+}
+
+// cookiesMatch is a predicate which tests whether the cookies of the given HTTP
+// request headers match, according to the cookie ignore rules of the matcher.
+func (m *defaultMatcher) cookiesMatch(x, y http.Header) bool {
+	return reflect.DeepEqual(m.cookieValues(x), m.cookieValues(y))
+}
+
+// cookieValues returns the sorted values of the cookies from the given HTTP
+// request header, keyed by the cookie name.
+func (m *defaultMatcher) cookieValues(header http.Header) map[string][]string {
+	r := &http.Request{Header: http.Header{"Cookie": header.Values("Cookie")}}
+
+	values := make(map[string][]string)
+	for _, cookie := range r.Cookies() {
+		if m.ignoreCookies[cookie.Name] {
+			continue
+		}
+
+		value := cookie.Value
+		if m.ignoreCookieValues[cookie.Name] {
+			value = ""
+		}
+		values[cookie.Name] = append(values[cookie.Name], value)
+	}
+
+	for _, v := range values {
+		sort.Strings(v)
+	}
+
+	return values
+}
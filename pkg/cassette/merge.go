@@ -0,0 +1,198 @@
+package cassette
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrSplitCollision is returned by [Split], when different keys result in the
+// same cassette name.
+var ErrSplitCollision = errors.New("split keys result in the same cassette name")
+
+// SplitFunc returns the key of the cassette, into which an interaction is
+// placed when splitting a cassette with [Split].
+type SplitFunc func(i *Interaction) string
+
+// unsafeKeyChars matches characters, which are replaced when deriving the name
+// of a cassette from a split key.
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SplitByHost is a [SplitFunc], which splits interactions by the host of the
+// request URL.
+func SplitByHost(i *Interaction) string {
+	u, err := url.Parse(i.Request.URL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// SplitByPathPrefix returns a [SplitFunc], which splits interactions by the
+// first of the given prefixes, which matches the path of the request URL.
+// Interactions, which don't match any of the prefixes get an empty key.
+func SplitByPathPrefix(prefixes ...string) SplitFunc {
+	fn := func(i *Interaction) string {
+		u, err := url.Parse(i.Request.URL)
+		if err != nil {
+			return ""
+		}
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(u.Path, prefix) {
+				return prefix
+			}
+		}
+
+		return ""
+	}
+
+	return fn
+}
+
+// SplitByHeader returns a [SplitFunc], which splits interactions by the value
+// of the given request header. Interactions without the header get an empty
+// key.
+func SplitByHeader(name string) SplitFunc {
+	fn := func(i *Interaction) string {
+		return i.Request.Headers.Get(name)
+	}
+
+	return fn
+}
+
+// SplitByTest is a [SplitFunc], which splits interactions by the test, which
+// recorded them, as recorded in the Test field of the interactions.
+// Interactions of unknown tests get an empty key.
+func SplitByTest(i *Interaction) string {
+	return i.Test
+}
+
+// Merge appends copies of the interactions from the source cassettes to the
+// destination cassette. Interactions marked with DiscardOnSave are skipped,
+// and the interaction IDs are renumbered the same way [Cassette.Save] does.
+func Merge(dst *Cassette, srcs ...*Cassette) {
+	clones := make([]*Interaction, 0)
+	parents := make(map[*Interaction]*Interaction)
+	for _, src := range srcs {
+		src.Lock()
+		srcClones := cloneInteractions(src.Interactions)
+		src.Unlock()
+
+		for i, parent := range linkParents(srcClones) {
+			parents[i] = parent
+		}
+		clones = append(clones, srcClones...)
+	}
+
+	dst.Lock()
+	defer dst.Unlock()
+	for i, parent := range linkParents(dst.Interactions) {
+		parents[i] = parent
+	}
+	dst.setInteractions(append(dst.Interactions, clones...), parents)
+}
+
+// Split splits the interactions of the given cassette into new cassettes
+// depending on the key returned by the [SplitFunc]. The resulting cassettes are
+// named after the source cassette and the key, e.g. splitting the "fixtures/api"
+// cassette by host results in cassettes like "fixtures/api-example.com". An
+// empty key results in a cassette with the "default" suffix. Interactions
+// marked with DiscardOnSave are skipped, and the interaction IDs are renumbered
+// the same way [Cassette.Save] does. [ErrSplitCollision] is returned, when
+// different keys result in the same cassette name, e.g. "a:1" and "a_1".
+func Split(c *Cassette, fn SplitFunc) (map[string]*Cassette, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	clones := cloneInteractions(c.Interactions)
+	parents := linkParents(clones)
+	groups := make(map[string][]*Interaction)
+	for _, i := range clones {
+		key := fn(i)
+		groups[key] = append(groups[key], i)
+	}
+
+	result := make(map[string]*Cassette)
+	names := make(map[string]string)
+	for key, interactions := range groups {
+		suffix := unsafeKeyChars.ReplaceAllString(key, "_")
+		if suffix == "" {
+			suffix = "default"
+		}
+
+		name := fmt.Sprintf("%s-%s", c.Name, suffix)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("%w: %q and %q result in %s", ErrSplitCollision, min(key, other), max(key, other), name)
+		}
+		names[name] = key
+
+		split := New(name)
+		split.setInteractions(interactions, parents)
+		result[key] = split
+	}
+
+	return result, nil
+}
+
+// cloneInteractions returns shallow copies of the given interactions.
+func cloneInteractions(interactions []*Interaction) []*Interaction {
+	clones := make([]*Interaction, 0, len(interactions))
+	for _, i := range interactions {
+		clone := *i
+		clones = append(clones, &clone)
+	}
+
+	return clones
+}
+
+// linkParents returns the parent of each interaction from the given ones, which
+// is part of a redirect chain.
+func linkParents(interactions []*Interaction) map[*Interaction]*Interaction {
+	byId := make(map[int]*Interaction, len(interactions))
+	for _, i := range interactions {
+		byId[i.ID] = i
+	}
+
+	parents := make(map[*Interaction]*Interaction)
+	for _, i := range interactions {
+		if i.ParentID == nil {
+			continue
+		}
+		if parent, ok := byId[*i.ParentID]; ok && parent != i {
+			parents[i] = parent
+		}
+	}
+
+	return parents
+}
+
+// setInteractions sets the interactions of the cassette, while skipping the
+// ones marked with DiscardOnSave and renumbering the interaction IDs. The
+// parent IDs of the interactions are updated according to the given parents,
+// and are cleared when the parent is not part of the cassette.
+func (c *Cassette) setInteractions(interactions []*Interaction, parents map[*Interaction]*Interaction) {
+	nextId := 0
+	kept := make(map[*Interaction]bool, len(interactions))
+	c.Interactions = make([]*Interaction, 0, len(interactions))
+	for _, i := range interactions {
+		if !i.DiscardOnSave {
+			i.ID = nextId
+			c.Interactions = append(c.Interactions, i)
+			kept[i] = true
+			nextId += 1
+		}
+	}
+	c.nextInteractionId = nextId
+
+	for _, i := range c.Interactions {
+		i.ParentID = nil
+		if parent := parents[i]; kept[parent] {
+			id := parent.ID
+			i.ParentID = &id
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package cassette
+
+// RedirectChains returns the redirect chains recorded in the cassette. Each
+// chain starts with the interaction of the initial request, followed by the
+// interactions of the redirect hops in the order they were made. Interactions,
+// which are not part of a redirect chain are not returned.
+func (c *Cassette) RedirectChains() [][]*Interaction {
+	c.Lock()
+	defer c.Unlock()
+
+	parents := linkParents(c.Interactions)
+	children := make(map[*Interaction][]*Interaction)
+	for _, i := range c.Interactions {
+		if parent, ok := parents[i]; ok {
+			children[parent] = append(children[parent], i)
+		}
+	}
+
+	chains := make([][]*Interaction, 0)
+	for _, i := range c.Interactions {
+		if _, ok := parents[i]; ok || len(children[i]) == 0 {
+			continue
+		}
+
+		// A replayed hop may have been followed more than once, in
+		// which case each of the followed hops starts a new chain
+		var walk func(chain []*Interaction, i *Interaction)
+		walk = func(chain []*Interaction, i *Interaction) {
+			chain = append(chain, i)
+			if len(children[i]) == 0 {
+				chains = append(chains, chain)
+				return
+			}
+			for _, child := range children[i] {
+				walk(chain[:len(chain):len(chain)], child)
+			}
+		}
+		walk(nil, i)
+	}
+
+	return chains
+}
+
+// RedirectChain returns the redirect chain, which leads to the given
+// interaction, starting with the interaction of the initial request and ending
+// with the given interaction.
+func (c *Cassette) RedirectChain(i *Interaction) []*Interaction {
+	c.Lock()
+	defer c.Unlock()
+
+	parents := linkParents(c.Interactions)
+	chain := []*Interaction{i}
+	seen := map[*Interaction]bool{i: true}
+	for parent := parents[i]; parent != nil && !seen[parent]; parent = parents[parent] {
+		chain = append([]*Interaction{parent}, chain...)
+		seen[parent] = true
+	}
+
+	return chain
+}
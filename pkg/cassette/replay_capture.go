@@ -0,0 +1,361 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Capture extracts a value from responses. When replaying interactions with
+// [WithReplayCapture], the value extracted from the recorded response is
+// substituted with the value extracted from the actual response in the
+// following interactions, i.e. in the paths of request URLs, and at the
+// locations, which refer to the captured field or header.
+type Capture struct {
+	// extract extracts the value from a response
+	extract func(header http.Header, body string) (string, bool)
+
+	// field is the name of the JSON field, which holds the value
+	field string
+
+	// header is the name of the header, which holds the value
+	header string
+}
+
+// CaptureJSONPath returns a [Capture], which extracts the value at the given
+// dot-separated path from a JSON response body, e.g. "data.id" or "items.0.id".
+// The value is substituted in the paths of request URLs, and in the values of
+// the fields with the same name, e.g. "id", of JSON bodies, forms and URL
+// queries.
+func CaptureJSONPath(path string) Capture {
+	keys := strings.Split(path, ".")
+	field := keys[len(keys)-1]
+	for n := len(keys) - 1; n >= 0; n-- {
+		if _, err := strconv.Atoi(keys[n]); err != nil {
+			field = keys[n]
+			break
+		}
+	}
+
+	c := Capture{
+		extract: func(header http.Header, body string) (string, bool) {
+			value, err := jsonPath([]byte(body), path)
+			if err != nil {
+				return "", false
+			}
+
+			return value, true
+		},
+		field: field,
+	}
+
+	return c
+}
+
+// CaptureHeader returns a [Capture], which extracts the value of the given
+// response header, e.g. Location. The value is substituted in the paths of
+// request URLs, and in the values of the header.
+func CaptureHeader(name string) Capture {
+	c := Capture{
+		extract: func(header http.Header, body string) (string, bool) {
+			value := header.Get(name)
+
+			return value, value != ""
+		},
+		header: http.CanonicalHeaderKey(name),
+	}
+
+	return c
+}
+
+// urlHeaders are the headers, which contain URLs, and in which the captured
+// values are substituted like in request URLs.
+var urlHeaders = []string{"Location", "Content-Location", "Referer"}
+
+// replayState holds the substitutions captured while replaying interactions
+// sequentially. The substitutions are applied in a single pass, so that a
+// substituted value is never substituted again.
+type replayState struct {
+	// values maps the recorded values in URLs to the actual ones
+	values map[string]string
+
+	// fields maps the names of JSON and form fields to the substitutions of
+	// their values
+	fields map[string]map[string]string
+
+	// headers maps the names of headers to the substitutions of their
+	// values
+	headers map[string]map[string]string
+}
+
+// capture extracts the values from the recorded and actual responses using the
+// given captures, and adds a substitution for each value, which differs.
+func (s *replayState) capture(captures []Capture, recorded *Interaction, header http.Header, body string) {
+	for _, c := range captures {
+		want, ok := c.extract(recorded.Response.Headers, recorded.Response.Body)
+		if !ok || want == "" {
+			continue
+		}
+
+		got, ok := c.extract(header, body)
+		if !ok || got == want {
+			continue
+		}
+
+		if s.values == nil {
+			s.values = make(map[string]string)
+			s.fields = make(map[string]map[string]string)
+			s.headers = make(map[string]map[string]string)
+		}
+
+		s.values[want] = got
+		if c.field != "" {
+			addSubstitution(s.fields, c.field, want, got)
+		}
+		if c.header != "" {
+			addSubstitution(s.headers, c.header, want, got)
+		}
+	}
+}
+
+// addSubstitution adds a substitution of the values of the given field.
+func addSubstitution(m map[string]map[string]string, field, recorded, actual string) {
+	if m[field] == nil {
+		m[field] = make(map[string]string)
+	}
+	m[field][recorded] = actual
+}
+
+// apply returns a copy of the given interaction, in which the captured values
+// are substituted in the request and the response.
+func (s *replayState) apply(i *Interaction) *Interaction {
+	clone := *i
+	if len(s.values) == 0 {
+		return &clone
+	}
+
+	clone.Request.URL = s.replaceURL(i.Request.URL)
+	clone.Request.RequestURI = s.replaceURL(i.Request.RequestURI)
+	clone.Request.Body = s.replaceBody(i.Request.Body)
+	clone.Request.Headers = s.replaceHeader(i.Request.Headers)
+	clone.Request.Form = s.replaceForm(i.Request.Form)
+	clone.Response.Body = s.replaceBody(i.Response.Body)
+	clone.Response.Headers = s.replaceHeader(i.Response.Headers)
+
+	// The content length changes, when the substituted values differ in
+	// length
+	if i.Request.Body != "" {
+		clone.Request.ContentLength = int64(len(clone.Request.Body))
+	}
+
+	return &clone
+}
+
+// replaceURL substitutes the captured values in the given URL, which replace
+// the whole path, single path segments, or the values of the query parameters
+// named like the captured fields.
+func (s *replayState) replaceURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return rawURL
+	}
+
+	replaced := false
+	if actual, ok := s.values[u.Path]; ok {
+		u.Path = actual
+		replaced = true
+	} else {
+		segments := strings.Split(u.Path, "/")
+		for n, segment := range segments {
+			if actual, ok := s.values[segment]; ok {
+				segments[n] = actual
+				replaced = true
+			}
+		}
+		u.Path = strings.Join(segments, "/")
+	}
+
+	query := u.Query()
+	for name, values := range query {
+		for n, v := range values {
+			if actual, ok := s.fields[name][v]; ok {
+				values[n] = actual
+				replaced = true
+			}
+		}
+	}
+
+	// URLs without substitutions are kept as they are
+	if !replaced {
+		return rawURL
+	}
+
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// replaceHeader returns a copy of the given header with the captured values
+// substituted in the values of the captured headers, and in URL headers.
+func (s *replayState) replaceHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+
+	replaced := header.Clone()
+	for name, values := range replaced {
+		for n, v := range values {
+			if actual, ok := s.headers[name][v]; ok {
+				values[n] = actual
+				continue
+			}
+
+			for _, h := range urlHeaders {
+				if name == h {
+					values[n] = s.replaceURL(v)
+				}
+			}
+		}
+	}
+
+	return replaced
+}
+
+// replaceForm returns a copy of the given form with the captured values
+// substituted in the values of the captured fields.
+func (s *replayState) replaceForm(form url.Values) url.Values {
+	if form == nil {
+		return nil
+	}
+
+	replaced := make(url.Values, len(form))
+	for name, values := range form {
+		for _, v := range values {
+			if actual, ok := s.fields[name][v]; ok {
+				v = actual
+			}
+			replaced[name] = append(replaced[name], v)
+		}
+	}
+
+	return replaced
+}
+
+// replaceBody substitutes the captured values in the scalar values of the
+// captured fields of a JSON body. Other bodies are returned unchanged.
+func (s *replayState) replaceBody(body string) string {
+	if len(s.fields) == 0 || !json.Valid([]byte(body)) {
+		return body
+	}
+
+	type span struct {
+		start, end int
+		value      string
+	}
+
+	// containers tracks the enclosing objects and arrays, and whether the
+	// next token of an object is a key
+	type container struct {
+		object    bool
+		expectKey bool
+		key       string
+	}
+
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	containers := make([]*container, 0)
+	spans := make([]span, 0)
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return body
+		}
+
+		var parent *container
+		if len(containers) > 0 {
+			parent = containers[len(containers)-1]
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				if parent != nil && parent.object {
+					parent.expectKey = true
+				}
+				containers = append(containers, &container{object: v == '{', expectKey: v == '{'})
+			default:
+				containers = containers[:len(containers)-1]
+			}
+			continue
+		case string:
+			if parent != nil && parent.object && parent.expectKey {
+				parent.key = v
+				parent.expectKey = false
+				continue
+			}
+		}
+
+		if parent == nil || !parent.object {
+			continue
+		}
+		parent.expectKey = true
+
+		var recorded string
+		switch v := tok.(type) {
+		case string:
+			recorded = v
+		case json.Number:
+			recorded = v.String()
+		default:
+			continue
+		}
+
+		actual, ok := s.fields[parent.key][recorded]
+		if !ok {
+			continue
+		}
+
+		// The value starts after the separator following the key
+		start := offset + strings.IndexFunc(body[offset:], func(r rune) bool {
+			return r != ' ' && r != '\t' && r != '\n' && r != '\r' && r != ':' && r != ','
+		})
+
+		value, err := json.Marshal(actual)
+		if err != nil {
+			continue
+		}
+		if _, isNumber := tok.(json.Number); isNumber {
+			if _, err := strconv.ParseFloat(actual, 64); err == nil {
+				value = []byte(actual)
+			}
+		}
+		spans = append(spans, span{start: start, end: int(dec.InputOffset()), value: string(value)})
+	}
+
+	if len(spans) == 0 {
+		return body
+	}
+
+	var b bytes.Buffer
+	last := 0
+	for _, sp := range spans {
+		b.WriteString(body[last:sp.start])
+		b.WriteString(sp.value)
+		last = sp.end
+	}
+	b.WriteString(body[last:])
+
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package cassette
+
+import (
+	"reflect"
+)
+
+// SequenceBehavior specifies what happens once all responses from the sequence
+// of an interaction have been replayed.
+type SequenceBehavior string
+
+const (
+	// SequenceError results in [ErrInteractionNotFound] once all responses
+	// from the sequence have been replayed. This is the default behavior.
+	SequenceError SequenceBehavior = "error"
+
+	// SequenceRepeatLast keeps replaying the last response from the
+	// sequence.
+	SequenceRepeatLast SequenceBehavior = "repeat_last"
+
+	// SequenceCycle starts replaying the responses from the beginning of
+	// the sequence.
+	SequenceCycle SequenceBehavior = "cycle"
+)
+
+// unlimited returns true, if the behavior allows replaying the sequence any
+// number of times.
+func (b SequenceBehavior) unlimited() bool {
+	return b == SequenceRepeatLast || b == SequenceCycle
+}
+
+// sequenceIndex returns the index of the response from the sequence, which is
+// to be replayed next.
+func (i *Interaction) sequenceIndex() int {
+	n := len(i.Sequence)
+	switch {
+	case i.replays < n:
+		return i.replays
+	case i.AfterSequence == SequenceCycle:
+		return i.replays % n
+	default:
+		return n - 1
+	}
+}
+
+// GroupSequences collapses consecutive interactions with identical requests,
+// but different responses into the first one of them, and marks the rest with
+// DiscardOnSave. The responses of the collapsed interactions become the
+// Sequence of the remaining interaction, in the order in which they were
+// recorded, and after specifies what happens once all of them have been
+// replayed. Interactions with identical requests, which are not consecutive,
+// are kept, so that the order of the interactions is preserved.
+func (c *Cassette) GroupSequences(after SequenceBehavior) {
+	c.Lock()
+	defer c.Unlock()
+
+	groups := make([][]*Interaction, 0)
+	for _, i := range c.Interactions {
+		if i.DiscardOnSave {
+			continue
+		}
+
+		if n := len(groups); n > 0 {
+			head := groups[n-1][0]
+			if len(head.Sequence) == 0 && len(i.Sequence) == 0 && reflect.DeepEqual(head.Request, i.Request) {
+				groups[n-1] = append(groups[n-1], i)
+				continue
+			}
+		}
+
+		groups = append(groups, []*Interaction{i})
+	}
+
+	for _, group := range groups {
+		if len(group) < 2 || allSame(group) {
+			continue
+		}
+
+		head := group[0]
+		head.Sequence = make([]Response, 0, len(group))
+		for _, i := range group {
+			head.Sequence = append(head.Sequence, i.Response)
+			if i != head {
+				i.DiscardOnSave = true
+			}
+		}
+		head.AfterSequence = after
+	}
+}
+
+// allSame returns true, if all the given interactions are the same, apart from
+// their [VolatileHeaders].
+func allSame(interactions []*Interaction) bool {
+	for _, i := range interactions[1:] {
+		if !interactions[0].sameAs(i, VolatileHeaders) {
+			return false
+		}
+	}
+
+	return true
+}
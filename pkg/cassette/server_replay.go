@@ -1,12 +1,16 @@
 package cassette
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"maps"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -16,48 +20,317 @@ import (
 type ReplayAssertFunc func(t *testing.T, expected *Interaction, actual *httptest.ResponseRecorder)
 
 // DefaultReplayAssertFunc compares the response status code, body, and headers.
-// It can be overridden for more specific tests or to use your preferred assertion libraries
+// It is used by [TestServerReplay] and [TestInteractionReplay], when neither an
+// assert function, nor comparison options were provided.
+//
+// Deprecated: Use [WithReplayAssertFunc] to use a different assert function,
+// instead of overriding this global variable.
 var DefaultReplayAssertFunc ReplayAssertFunc = func(t *testing.T, expected *Interaction, actual *httptest.ResponseRecorder) {
+	(&serverReplayOptions{}).assertResponse(t, expected, actual)
+}
+
+// ReplayUpdateEnv is the name of the environment variable, which enables the
+// update mode of [TestServerReplay] and [TestInteractionReplay], when set to a
+// true value, e.g. GO_VCR_UPDATE=1. See [WithReplayUpdate].
+const ReplayUpdateEnv = "GO_VCR_UPDATE"
+
+// ServerReplayOption is a function, which configures [TestServerReplay] and
+// [TestInteractionReplay].
+type ServerReplayOption func(o *serverReplayOptions)
+
+// serverReplayOptions configures the replay of interactions against a handler.
+type serverReplayOptions struct {
+	// assert is the function used to assert the results of replaying an
+	// interaction.
+	assert ReplayAssertFunc
+
+	// ignoreHeaders are the response headers, which are not compared.
+	ignoreHeaders []string
+
+	// jsonBody specifies whether JSON bodies are compared semantically.
+	jsonBody bool
+
+	// filters select the interactions, which are replayed.
+	filters []func(i *Interaction) bool
+
+	// captures extract the values from responses, which are substituted
+	// in the following interactions.
+	captures []Capture
+
+	// update specifies whether the recorded responses are updated with the
+	// actual responses of the handler, instead of asserting them.
+	update bool
+}
+
+// WithReplayAssertFunc is a [ServerReplayOption], which configures the function
+// used to assert the results of replaying an interaction. When set, the
+// comparison options, e.g. [WithReplayIgnoreHeaders] have no effect.
+func WithReplayAssertFunc(fn ReplayAssertFunc) ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.assert = fn
+	}
+
+	return opt
+}
+
+// WithReplayIgnoreHeaders is a [ServerReplayOption], which configures the
+// comparison to ignore the given response headers, e.g. Date or request IDs,
+// which change whenever the handler is invoked.
+func WithReplayIgnoreHeaders(names ...string) ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.ignoreHeaders = append(o.ignoreHeaders, names...)
+	}
+
+	return opt
+}
+
+// WithReplayJSONBody is a [ServerReplayOption], which configures the comparison
+// to compare JSON response bodies semantically, so that differences in
+// formatting and in the order of object keys are ignored. Bodies, which are not
+// valid JSON are compared as is.
+func WithReplayJSONBody() ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.jsonBody = true
+	}
+
+	return opt
+}
+
+// WithReplayFilter is a [ServerReplayOption], which configures
+// [TestServerReplay] to replay only the interactions, for which the given
+// function returns true. When used multiple times, only the interactions
+// matching all filters are replayed.
+func WithReplayFilter(fn func(i *Interaction) bool) ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.filters = append(o.filters, fn)
+	}
+
+	return opt
+}
+
+// WithReplayCapture is a [ServerReplayOption], which configures
+// [TestServerReplay] to replay the interactions sequentially, while capturing
+// values from the responses of the handler using the given captures. When a
+// captured value differs from the value in the recorded response, e.g. the ID
+// of a newly created resource, the recorded value is substituted with the actual
+// one in the expected response, and in the requests and the expected responses
+// of the following interactions. See [Capture] for the locations, in which the
+// values are substituted.
+// This allows verifying flows, where a later request depends on a value
+// returned earlier, against a handler with fresh state.
+//
+//	cassette.TestServerReplay(t, "fixtures/users", handler,
+//		cassette.WithReplayCapture(
+//			cassette.CaptureJSONPath("id"),
+//			cassette.CaptureHeader("Location"),
+//		),
+//	)
+func WithReplayCapture(captures ...Capture) ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.captures = append(o.captures, captures...)
+	}
+
+	return opt
+}
+
+// WithReplayUpdate is a [ServerReplayOption], which configures the update mode.
+// In update mode the actual responses of the handler are written back to the
+// interactions instead of asserting them, and [TestServerReplay] saves the
+// cassette once all interactions were replayed. This is useful for updating
+// cassettes, when the behavior of the handler changes intentionally, similar to
+// updating golden files. By default, the update mode is enabled using the
+// [ReplayUpdateEnv] environment variable. The option can be used to enable it
+// using a test flag instead.
+//
+//	var update = flag.Bool("update", false, "update cassettes")
+//
+//	cassette.TestServerReplay(t, "fixtures/users", handler, cassette.WithReplayUpdate(*update))
+func WithReplayUpdate(val bool) ServerReplayOption {
+	opt := func(o *serverReplayOptions) {
+		o.update = val
+	}
+
+	return opt
+}
+
+// newServerReplayOptions creates the replay options from the given options.
+func newServerReplayOptions(opts ...ServerReplayOption) *serverReplayOptions {
+	o := &serverReplayOptions{
+		ignoreHeaders: make([]string, 0),
+		filters:       make([]func(i *Interaction) bool, 0),
+		captures:      make([]Capture, 0),
+		update:        false,
+	}
+
+	if val, err := strconv.ParseBool(os.Getenv(ReplayUpdateEnv)); err == nil {
+		o.update = val
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// selected returns true, if the given interaction passes all filters.
+func (o *serverReplayOptions) selected(i *Interaction) bool {
+	for _, filter := range o.filters {
+		if !filter(i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// assertFunc returns the function used to assert the results of replaying an
+// interaction.
+func (o *serverReplayOptions) assertFunc() ReplayAssertFunc {
+	switch {
+	case o.assert != nil:
+		return o.assert
+	case len(o.ignoreHeaders) > 0 || o.jsonBody:
+		return o.assertResponse
+	default:
+		return DefaultReplayAssertFunc
+	}
+}
+
+// assertResponse compares the response status code, body, and headers
+// according to the comparison options.
+func (o *serverReplayOptions) assertResponse(t *testing.T, expected *Interaction, actual *httptest.ResponseRecorder) {
+	t.Helper()
+
 	if expected.Response.Code != actual.Result().StatusCode {
 		t.Errorf("status code does not match: expected=%d actual=%d", expected.Response.Code, actual.Result().StatusCode)
 	}
 
-	if expected.Response.Body != actual.Body.String() {
+	if !o.bodiesEqual(expected.Response.Body, actual.Body.String()) {
 		t.Errorf("body does not match: expected=%s actual=%s", expected.Response.Body, actual.Body.String())
 	}
 
-	if !headersEqual(expected.Response.Headers, actual.Header()) {
-		t.Errorf("header values do not match. expected=%v actual=%v", expected.Response.Headers, actual.Header())
+	expectedHeader := expected.Response.Headers.Clone()
+	actualHeader := actual.Header().Clone()
+	for _, name := range o.ignoreHeaders {
+		expectedHeader.Del(name)
+		actualHeader.Del(name)
+	}
+
+	if !headersEqual(expectedHeader, actualHeader) {
+		t.Errorf("header values do not match. expected=%v actual=%v", expectedHeader, actualHeader)
+	}
+}
+
+// bodiesEqual returns true, if the given response bodies are equal.
+func (o *serverReplayOptions) bodiesEqual(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+
+	if !o.jsonBody {
+		return false
+	}
+
+	var x, y any
+	if json.Unmarshal([]byte(expected), &x) != nil || json.Unmarshal([]byte(actual), &y) != nil {
+		return false
 	}
+
+	return reflect.DeepEqual(x, y)
 }
 
 // TestServerReplay loads a Cassette and replays each Interaction with the provided Handler, then compares the response
-func TestServerReplay(t *testing.T, cassetteName string, handler http.Handler) {
+func TestServerReplay(t *testing.T, cassetteName string, handler http.Handler, opts ...ServerReplayOption) {
 	t.Helper()
 
 	c, err := Load(cassetteName)
 	if err != nil {
-		t.Errorf("unexpected error loading Cassette: %v", err)
+		t.Fatalf("unexpected error loading Cassette: %v", err)
 	}
 
 	if len(c.Interactions) == 0 {
 		t.Error("no interactions in Cassette")
 	}
 
+	o := newServerReplayOptions(opts...)
+	state := &replayState{}
 	for _, interaction := range c.Interactions {
+		if !o.selected(interaction) {
+			continue
+		}
+
 		t.Run(fmt.Sprintf("Interaction_%d", interaction.ID), func(t *testing.T) {
-			TestInteractionReplay(t, handler, interaction)
+			req := state.apply(interaction)
+			w := o.serve(t, handler, req)
+
+			// Values captured from the response are substituted in
+			// the expected response as well
+			state.capture(o.captures, interaction, w.Header(), w.Body.String())
+			if o.update {
+				interaction.Request = req.Request
+				updateResponse(interaction, w)
+				return
+			}
+
+			assert := o.assertFunc()
+			assert(t, state.apply(interaction), w)
 		})
 	}
+
+	if o.update {
+		if err := c.Save(); err != nil {
+			t.Fatalf("unexpected error saving Cassette: %v", err)
+		}
+	}
+}
+
+// TestInteractionReplay replays an Interaction with the provided Handler and compares the response.
+// In update mode the response of the interaction is updated instead, and it is up to the caller to
+// save the cassette.
+func TestInteractionReplay(t *testing.T, handler http.Handler, interaction *Interaction, opts ...ServerReplayOption) {
+	t.Helper()
+
+	o := newServerReplayOptions(opts...)
+	w := o.serve(t, handler, interaction)
+	if o.update {
+		updateResponse(interaction, w)
+		return
+	}
+
+	assert := o.assertFunc()
+	assert(t, interaction, w)
+}
+
+// updateResponse sets the response of the interaction to the actual response
+// of the handler.
+func updateResponse(interaction *Interaction, w *httptest.ResponseRecorder) {
+	resp := w.Result()
+	interaction.Response = Response{
+		Proto:            resp.Proto,
+		ProtoMajor:       resp.ProtoMajor,
+		ProtoMinor:       resp.ProtoMinor,
+		TransferEncoding: resp.TransferEncoding,
+		Trailer:          resp.Trailer,
+		ContentLength:    resp.ContentLength,
+		Uncompressed:     resp.Uncompressed,
+		Body:             w.Body.String(),
+		Headers:          resp.Header,
+		Status:           resp.Status,
+		Code:             resp.StatusCode,
+	}
+	interaction.Sequence = nil
+	interaction.Template = false
 }
 
-// TestInteractionReplay replays an Interaction with the provided Handler and compares the response
-func TestInteractionReplay(t *testing.T, handler http.Handler, interaction *Interaction) {
+// serve replays the request of the interaction with the provided handler, and
+// returns the response of the handler.
+func (o *serverReplayOptions) serve(t *testing.T, handler http.Handler, interaction *Interaction) *httptest.ResponseRecorder {
 	t.Helper()
 
 	req, err := interaction.GetHTTPRequest()
 	if err != nil {
-		t.Errorf("unexpected error getting interaction request: %v", err)
+		t.Fatalf("unexpected error getting interaction request: %v", err)
 	}
 
 	if len(req.Form) > 0 {
@@ -67,7 +340,7 @@ func TestInteractionReplay(t *testing.T, handler http.Handler, interaction *Inte
 	w := httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 
-	DefaultReplayAssertFunc(t, interaction, w)
+	return w
 }
 
 func headersEqual(expected, actual http.Header) bool {
@@ -0,0 +1,164 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// RenderHTTPResponse converts the recorded interaction response to
+// http.Response instance, similar to [Interaction.GetHTTPResponse]. If the
+// interaction is marked as a template, the body and header values of the
+// response are expanded as [text/template] templates using the given live
+// request as data. The following functions are available to templates.
+//
+//   - header "Name" returns the value of a request header
+//   - query "name" returns the value of a URL query parameter
+//   - form "name" returns the value of a form parameter
+//   - json "path.to.0.field" returns a value from the JSON request body
+//   - toJSON encodes a value as JSON, e.g. a string as a quoted and escaped
+//     JSON string
+//   - now returns the current time
+//
+// Values are inserted as is, so values inserted into JSON bodies should be
+// encoded using toJSON. For example, a response body of
+// {"id": {{ json "id" | toJSON }}} echoes the id from the JSON request body,
+// even if it contains quotes.
+func (i *Interaction) RenderHTTPResponse(r *http.Request) (*http.Response, error) {
+	resp, err := i.GetHTTPResponse()
+	if err != nil || !i.Template {
+		return resp, err
+	}
+
+	funcs, err := templateFuncs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := renderTemplate("body", i.Response.Body, r, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header, len(i.Response.Headers))
+	for name, values := range i.Response.Headers {
+		for _, value := range values {
+			rendered, err := renderTemplate(name, value, r, funcs)
+			if err != nil {
+				return nil, err
+			}
+			header.Add(name, rendered)
+		}
+	}
+
+	if resp.ContentLength >= 0 {
+		resp.ContentLength = int64(len(body))
+	}
+	if header.Get("Content-Length") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	resp.Header = header
+	resp.Body = io.NopCloser(strings.NewReader(body))
+
+	return resp, nil
+}
+
+// renderTemplate expands the given template text using the live request.
+func renderTemplate(name, text string, r *http.Request, funcs template.FuncMap) (string, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, r); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// templateFuncs returns the functions available to response templates, which
+// refer to the given live request.
+func templateFuncs(r *http.Request) (template.FuncMap, error) {
+	var body []byte
+	if r.Body != nil {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = data
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+
+	funcs := template.FuncMap{
+		"header": r.Header.Get,
+		"query":  r.URL.Query().Get,
+		"form": func(name string) (string, error) {
+			clone := r.Clone(r.Context())
+			clone.Body = io.NopCloser(bytes.NewReader(body))
+			if err := clone.ParseForm(); err != nil {
+				return "", err
+			}
+			return clone.Form.Get(name), nil
+		},
+		"json": func(path string) (string, error) {
+			return jsonPath(body, path)
+		},
+		"toJSON": toJSON,
+		"now":    time.Now,
+	}
+
+	return funcs, nil
+}
+
+// jsonPath returns the value at the given dot-separated path from the JSON
+// document. Strings are returned as is, while other values are encoded as
+// JSON.
+func jsonPath(data []byte, path string) (string, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("body is not valid JSON: %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			item, ok := v[key]
+			if !ok {
+				return "", fmt.Errorf("json path %q not found in body", path)
+			}
+			value = item
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return "", fmt.Errorf("json path %q not found in body", path)
+			}
+			value = v[idx]
+		default:
+			return "", fmt.Errorf("json path %q not found in body", path)
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	return toJSON(value)
+}
+
+// toJSON encodes the given value as JSON.
+func toJSON(value any) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encoded), nil
+}
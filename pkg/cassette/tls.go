@@ -0,0 +1,134 @@
+package cassette
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// TLS represents the relevant parts of the TLS connection state of a response
+// as recorded in the cassette file.
+type TLS struct {
+	// Version is the name of the TLS version used by the connection
+	Version string `yaml:"version"`
+
+	// CipherSuite is the name of the cipher suite negotiated for the
+	// connection
+	CipherSuite string `yaml:"cipher_suite"`
+
+	// NegotiatedProtocol is the application protocol negotiated with ALPN
+	NegotiatedProtocol string `yaml:"negotiated_protocol,omitempty"`
+
+	// ServerName is the value of the Server Name Indication extension
+	ServerName string `yaml:"server_name,omitempty"`
+
+	// HandshakeComplete is true if the handshake has concluded
+	HandshakeComplete bool `yaml:"handshake_complete"`
+
+	// DidResume is true if the connection resumed a previous session
+	DidResume bool `yaml:"did_resume,omitempty"`
+
+	// PeerCertificates is the certificate chain presented by the peer,
+	// encoded in PEM format
+	PeerCertificates []string `yaml:"peer_certificates,omitempty"`
+}
+
+// tlsVersions are the supported TLS versions
+var tlsVersions = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	tls.VersionTLS13,
+}
+
+// NewTLS creates a new [TLS] from the given TLS connection state. It returns
+// nil, if the connection state is nil.
+func NewTLS(state *tls.ConnectionState) *TLS {
+	if state == nil {
+		return nil
+	}
+
+	t := &TLS{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ServerName:         state.ServerName,
+		HandshakeComplete:  state.HandshakeComplete,
+		DidResume:          state.DidResume,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		block := &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}
+		t.PeerCertificates = append(t.PeerCertificates, string(pem.EncodeToMemory(block)))
+	}
+
+	return t
+}
+
+// ConnectionState converts the recorded TLS details to a TLS connection state.
+func (t *TLS) ConnectionState() (*tls.ConnectionState, error) {
+	version, err := parseTLSVersion(t.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuite, err := parseCipherSuite(t.CipherSuite)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &tls.ConnectionState{
+		Version:            version,
+		CipherSuite:        cipherSuite,
+		NegotiatedProtocol: t.NegotiatedProtocol,
+		ServerName:         t.ServerName,
+		HandshakeComplete:  t.HandshakeComplete,
+		DidResume:          t.DidResume,
+	}
+
+	for _, data := range t.PeerCertificates {
+		block, _ := pem.Decode([]byte(data))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("invalid PEM encoded peer certificate")
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		state.PeerCertificates = append(state.PeerCertificates, cert)
+	}
+
+	return state, nil
+}
+
+// parseTLSVersion returns the TLS version with the given name.
+func parseTLSVersion(name string) (uint16, error) {
+	for _, version := range tlsVersions {
+		if tls.VersionName(version) == name {
+			return version, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown TLS version %q", name)
+}
+
+// parseCipherSuite returns the cipher suite with the given name.
+func parseCipherSuite(name string) (uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, suite := range suites {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+
+	// TLS 1.3 cipher suites are not part of the lists above in older Go
+	// versions, and unknown ones are named after their ID
+	var id uint16
+	if _, err := fmt.Sscanf(name, "0x%04X", &id); err == nil {
+		return id, nil
+	}
+
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
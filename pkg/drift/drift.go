@@ -0,0 +1,397 @@
+// Package drift provides a verifier, which detects when the interactions
+// recorded in a cassette no longer represent the behavior of the real service.
+//
+// The verifier re-issues each recorded request against the real service, or a
+// local stand-in of it, and compares the live response with the recorded one
+// using a set of configurable rules. Rather than comparing exact values, which
+// are expected to change, the rules check that the live response is compatible
+// with the recorded one, e.g. that the status code is the same, that the
+// required headers are present, and that JSON bodies have a compatible schema.
+package drift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// Rule names used in the reported drifts
+const (
+	// RuleStatus reports a different status code
+	RuleStatus = "status"
+
+	// RuleHeader reports a missing response header
+	RuleHeader = "header"
+
+	// RuleSchema reports an incompatible JSON response body
+	RuleSchema = "schema"
+)
+
+// ErrUnsafeMethod is the error of results, which were skipped, because the
+// request uses an unsafe method and the verifier was not configured to issue
+// such requests.
+var ErrUnsafeMethod = errors.New("request uses an unsafe method")
+
+// Drift describes a difference between the recorded and the live response.
+type Drift struct {
+	// Rule is the name of the rule, which detected the drift
+	Rule string
+
+	// Message describes the drift
+	Message string
+}
+
+// String implements the [fmt.Stringer] interface.
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: %s", d.Rule, d.Message)
+}
+
+// Result is the result of verifying a single interaction.
+type Result struct {
+	// Interaction is the verified interaction
+	Interaction *cassette.Interaction
+
+	// Status is the status code of the live response
+	Status int
+
+	// Drifts are the detected differences between the recorded and the
+	// live response
+	Drifts []Drift
+
+	// Err is the error, which prevented verifying the interaction, e.g.
+	// a failed request or [ErrUnsafeMethod] for skipped interactions
+	Err error
+}
+
+// OK returns true, if the interaction was verified and no drift was detected.
+func (r *Result) OK() bool {
+	return r.Err == nil && len(r.Drifts) == 0
+}
+
+// Report is the drift report of a cassette.
+type Report struct {
+	// Cassette is the name of the verified cassette
+	Cassette string
+
+	// Results are the results of verifying the interactions of the
+	// cassette, in the order of the interactions
+	Results []*Result
+}
+
+// HasDrift returns true, if a drift was detected for any of the interactions.
+func (r *Report) HasDrift() bool {
+	for _, result := range r.Results {
+		if len(result.Drifts) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Failed returns true, if any of the interactions could not be verified, e.g.
+// because the request failed. Interactions, which were skipped because of
+// [ErrUnsafeMethod], are not considered failed.
+func (r *Report) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil && !errors.Is(result.Err, ErrUnsafeMethod) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteTo writes the report in a human-readable form to the given writer.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	var drifted, failed, skipped int
+	for _, result := range r.Results {
+		i := result.Interaction
+		status := "ok"
+		switch {
+		case errors.Is(result.Err, ErrUnsafeMethod):
+			status = "skipped"
+			skipped += 1
+		case result.Err != nil:
+			status = "error"
+			failed += 1
+		case len(result.Drifts) > 0:
+			status = "drift"
+			drifted += 1
+		}
+
+		fmt.Fprintf(&b, "[%d] %s %s: %s\n", i.ID, i.Request.Method, i.Request.URL, status)
+		if result.Err != nil && status == "error" {
+			fmt.Fprintf(&b, "    %s\n", result.Err)
+		}
+		for _, d := range result.Drifts {
+			fmt.Fprintf(&b, "    %s\n", d)
+		}
+	}
+
+	fmt.Fprintf(&b, "%s: %d interactions, %d drifted, %d failed, %d skipped\n",
+		r.Cassette, len(r.Results), drifted, failed, skipped)
+
+	n, err := io.WriteString(w, b.String())
+
+	return int64(n), err
+}
+
+// Option is a function which configures the verifier.
+type Option func(v *verifier)
+
+// verifier re-issues recorded requests and compares the responses.
+type verifier struct {
+	// transport is used to issue the requests
+	transport http.RoundTripper
+
+	// baseURL replaces the scheme and host of the recorded requests
+	baseURL *url.URL
+
+	// unsafeMethods specifies whether to issue requests, which are not
+	// considered "Safe Methods", according to RFC 9110, section 9.2.1.
+	unsafeMethods bool
+
+	// status specifies whether to compare the status codes
+	status bool
+
+	// headers are the response headers, which must be present in the live
+	// response, when present in the recorded one
+	headers []string
+
+	// schema specifies whether to compare the schema of JSON bodies
+	schema bool
+
+	// filter selects the interactions, which are verified
+	filter func(i *cassette.Interaction) bool
+}
+
+// WithTransport is an [Option], which configures the verifier to issue the
+// requests using the given transport. By default [http.DefaultTransport] is
+// used.
+func WithTransport(rt http.RoundTripper) Option {
+	opt := func(v *verifier) {
+		v.transport = rt
+	}
+
+	return opt
+}
+
+// WithBaseURL is an [Option], which configures the verifier to issue the
+// requests against the given base URL, e.g. a local stand-in of the real
+// service, instead of the recorded scheme and host.
+func WithBaseURL(baseURL *url.URL) Option {
+	opt := func(v *verifier) {
+		v.baseURL = baseURL
+	}
+
+	return opt
+}
+
+// WithUnsafeMethods is an [Option], which configures the verifier whether to
+// issue requests, which are not considered "Safe Methods", according to
+// RFC 9110, section 9.2.1. By default such requests are skipped, since they may
+// have side effects on the real service.
+func WithUnsafeMethods(val bool) Option {
+	opt := func(v *verifier) {
+		v.unsafeMethods = val
+	}
+
+	return opt
+}
+
+// WithStatus is an [Option], which configures the verifier whether to compare
+// the status codes of the responses. It is enabled by default.
+func WithStatus(val bool) Option {
+	opt := func(v *verifier) {
+		v.status = val
+	}
+
+	return opt
+}
+
+// WithRequiredHeaders is an [Option], which configures the verifier to report
+// the given response headers, when they are present in the recorded response,
+// but missing from the live one. By default the Content-Type header is
+// required.
+func WithRequiredHeaders(names ...string) Option {
+	opt := func(v *verifier) {
+		v.headers = names
+	}
+
+	return opt
+}
+
+// WithSchema is an [Option], which configures the verifier whether to compare
+// the schema of JSON response bodies. The live body is compatible with the
+// recorded one, if it contains all recorded object fields with values of the
+// same type, while values and additional fields are ignored. It is enabled by
+// default.
+func WithSchema(val bool) Option {
+	opt := func(v *verifier) {
+		v.schema = val
+	}
+
+	return opt
+}
+
+// WithFilter is an [Option], which configures the verifier to verify only the
+// interactions, for which the given function returns true.
+func WithFilter(fn func(i *cassette.Interaction) bool) Option {
+	opt := func(v *verifier) {
+		v.filter = fn
+	}
+
+	return opt
+}
+
+// Verify re-issues the recorded requests of the cassette, compares the live
+// responses with the recorded ones, and returns the drift report. An error is
+// returned only if the context is done, while failures of individual requests
+// are part of the report.
+func Verify(ctx context.Context, c *cassette.Cassette, opts ...Option) (*Report, error) {
+	v := &verifier{
+		transport:     http.DefaultTransport,
+		unsafeMethods: false,
+		status:        true,
+		headers:       []string{"Content-Type"},
+		schema:        true,
+		filter:        func(i *cassette.Interaction) bool { return true },
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	report := &Report{
+		Cassette: c.Name,
+		Results:  make([]*Result, 0, len(c.Interactions)),
+	}
+
+	for _, i := range c.Interactions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !v.filter(i) {
+			continue
+		}
+
+		report.Results = append(report.Results, v.verify(ctx, i))
+	}
+
+	return report, nil
+}
+
+// verify verifies a single interaction.
+func (v *verifier) verify(ctx context.Context, i *cassette.Interaction) *Result {
+	result := &Result{
+		Interaction: i,
+		Drifts:      make([]Drift, 0),
+	}
+
+	if !v.unsafeMethods && !isSafeMethod(i.Request.Method) {
+		result.Err = ErrUnsafeMethod
+		return result
+	}
+
+	req, err := v.newRequest(ctx, i)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	resp, err := v.transport.RoundTrip(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Status = resp.StatusCode
+
+	recorded := i.Response
+	if len(i.Sequence) > 0 {
+		recorded = i.Sequence[0]
+	}
+
+	if v.status && recorded.Code != resp.StatusCode {
+		result.Drifts = append(result.Drifts, Drift{
+			Rule:    RuleStatus,
+			Message: fmt.Sprintf("recorded %d, got %d", recorded.Code, resp.StatusCode),
+		})
+	}
+
+	for _, name := range v.headers {
+		if recorded.Headers.Get(name) != "" && resp.Header.Get(name) == "" {
+			result.Drifts = append(result.Drifts, Drift{
+				Rule:    RuleHeader,
+				Message: fmt.Sprintf("%s header is missing", http.CanonicalHeaderKey(name)),
+			})
+		}
+	}
+
+	if v.schema {
+		for _, msg := range compareSchema(recorded.Body, string(body)) {
+			result.Drifts = append(result.Drifts, Drift{Rule: RuleSchema, Message: msg})
+		}
+	}
+
+	return result
+}
+
+// newRequest creates the HTTP request to issue for the given interaction.
+func (v *verifier) newRequest(ctx context.Context, i *cassette.Interaction) (*http.Request, error) {
+	u, err := url.Parse(i.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.baseURL != nil {
+		u.Scheme = v.baseURL.Scheme
+		u.Host = v.baseURL.Host
+		u.Path = strings.TrimSuffix(v.baseURL.Path, "/") + u.Path
+		u.RawPath = ""
+	}
+
+	req, err := http.NewRequestWithContext(ctx, i.Request.Method, u.String(), strings.NewReader(i.Request.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = i.Request.Headers.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+
+	// Let the transport negotiate and decode compressed responses
+	req.Header.Del("Accept-Encoding")
+	if v.baseURL == nil && i.Request.Host != "" {
+		req.Host = i.Request.Host
+	}
+
+	return req, nil
+}
+
+// isSafeMethod returns true for the "Safe Methods" as defined in RFC 9110,
+// section 9.2.1.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,216 @@
+package drift_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/drift"
+)
+
+func newCassette(interactions ...*cassette.Interaction) *cassette.Cassette {
+	c := cassette.New("fixtures/drift")
+	for _, i := range interactions {
+		c.AddInteraction(i)
+	}
+
+	return c
+}
+
+func newInteraction(method, path string, code int, body string) *cassette.Interaction {
+	i := &cassette.Interaction{
+		Request: cassette.Request{
+			Method: method,
+			URL:    "http://api.example.com" + path,
+		},
+		Response: cassette.Response{
+			Code:    code,
+			Body:    body,
+			Headers: http.Header{},
+		},
+	}
+
+	if body != "" {
+		i.Response.Headers.Set("Content-Type", "application/json")
+	}
+
+	return i
+}
+
+func TestVerify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": 2, "name": "Jess", "tags": ["b"], "email": "jess@example.com"}`)
+	})
+	mux.HandleFunc("/users/2", func(w http.ResponseWriter, r *http.Request) {
+		// Prevent the Content-Type header from being detected
+		w.Header()["Content-Type"] = nil
+		fmt.Fprint(w, `{"id": "2", "tags": [1]}`)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := newCassette(
+		newInteraction(http.MethodGet, "/users/1", http.StatusOK, `{"id": 1, "name": "Ava", "tags": ["a"], "manager": null}`),
+		newInteraction(http.MethodGet, "/users/2", http.StatusOK, `{"id": 2, "name": "Ava", "tags": ["a"]}`),
+		newInteraction(http.MethodGet, "/health", http.StatusOK, ""),
+		newInteraction(http.MethodDelete, "/users/1", http.StatusNoContent, ""),
+	)
+
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := drift.Verify(context.Background(), c, drift.WithBaseURL(baseURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.HasDrift() {
+		t.Fatal("expected drift to be detected")
+	}
+
+	if len(report.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(report.Results))
+	}
+
+	// Different values and additional fields are compatible
+	if !report.Results[0].OK() {
+		t.Fatalf("unexpected drift for compatible response: %v %v", report.Results[0].Drifts, report.Results[0].Err)
+	}
+
+	wantDrifts := [][]string{
+		nil,
+		{
+			"header: Content-Type header is missing",
+			"schema: $.id: recorded number, got string",
+			"schema: $.name: field is missing",
+			"schema: $.tags[0]: recorded string, got number",
+		},
+		{
+			"status: recorded 200, got 503",
+		},
+	}
+
+	for idx, want := range wantDrifts {
+		got := make([]string, 0)
+		for _, d := range report.Results[idx].Drifts {
+			got = append(got, d.String())
+		}
+
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("unexpected drifts for interaction %d:\nwant: %q\ngot:  %q", idx, want, got)
+		}
+	}
+
+	// Unsafe methods are skipped by default
+	if !errors.Is(report.Results[3].Err, drift.ErrUnsafeMethod) {
+		t.Fatalf("expected unsafe method to be skipped, got %v", report.Results[3].Err)
+	}
+
+	if report.Failed() {
+		t.Fatal("skipped interactions must not fail the report")
+	}
+
+	var out bytes.Buffer
+	if _, err := report.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSummary := "fixtures/drift: 4 interactions, 2 drifted, 0 failed, 1 skipped\n"
+	if !strings.HasSuffix(out.String(), wantSummary) {
+		t.Fatalf("unexpected report:\n%s", out.String())
+	}
+}
+
+func TestVerifyFailed(t *testing.T) {
+	// The service is unreachable
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newCassette(newInteraction(http.MethodGet, "/users/1", http.StatusOK, `{"id": 1}`))
+	report, err := drift.Verify(context.Background(), c, drift.WithBaseURL(baseURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Results[0].Err == nil {
+		t.Fatal("expected the request to fail")
+	}
+
+	if !report.Failed() || report.HasDrift() {
+		t.Fatalf("expected a failed report without drift, got failed %v, drift %v", report.Failed(), report.HasDrift())
+	}
+}
+
+func TestVerifyOptions(t *testing.T) {
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "not json")
+	}))
+	defer server.Close()
+
+	c := newCassette(
+		newInteraction(http.MethodPost, "/users", http.StatusCreated, `{"id": 1}`),
+		newInteraction(http.MethodGet, "/users", http.StatusOK, `[]`),
+	)
+
+	// The test server is used as the real transport for the recorded URLs
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []drift.Option{
+		drift.WithBaseURL(baseURL),
+		drift.WithTransport(server.Client().Transport),
+		drift.WithUnsafeMethods(true),
+		drift.WithStatus(false),
+		drift.WithRequiredHeaders(),
+		drift.WithFilter(func(i *cassette.Interaction) bool {
+			return i.Request.Method == http.MethodPost
+		}),
+	}
+
+	report, err := drift.Verify(context.Background(), c, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Results) != 1 || len(methods) != 1 || methods[0] != http.MethodPost {
+		t.Fatalf("expected only the POST request to be issued, got %v", methods)
+	}
+
+	drifts := report.Results[0].Drifts
+	if len(drifts) != 1 || drifts[0].Rule != drift.RuleSchema {
+		t.Fatalf("expected a single schema drift, got %v", drifts)
+	}
+
+	report, err = drift.Verify(context.Background(), c, append(opts, drift.WithSchema(false))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.HasDrift() {
+		t.Fatalf("expected no drift with all rules disabled, got %v", report.Results[0].Drifts)
+	}
+}
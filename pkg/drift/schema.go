@@ -0,0 +1,90 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// compareSchema compares the schema of the given JSON bodies, and returns the
+// incompatibilities of the live body. Recorded bodies, which are not JSON are
+// not compared.
+func compareSchema(recorded, live string) []string {
+	var want any
+	if strings.TrimSpace(recorded) == "" || json.Unmarshal([]byte(recorded), &want) != nil {
+		return nil
+	}
+
+	var got any
+	if err := json.Unmarshal([]byte(live), &got); err != nil {
+		return []string{"body is no longer valid JSON"}
+	}
+
+	return compareValue("$", want, got)
+}
+
+// compareValue compares the types of the given JSON values at the given path.
+func compareValue(path string, want, got any) []string {
+	// Recorded null values do not tell anything about the type
+	if want == nil {
+		return nil
+	}
+
+	if jsonType(want) != jsonType(got) {
+		return []string{fmt.Sprintf("%s: recorded %s, got %s", path, jsonType(want), jsonType(got))}
+	}
+
+	problems := make([]string, 0)
+	switch w := want.(type) {
+	case map[string]any:
+		g := got.(map[string]any)
+		keys := make([]string, 0, len(w))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			// Fields with recorded null values may be omitted
+			item, ok := g[k]
+			if !ok && w[k] != nil {
+				problems = append(problems, fmt.Sprintf("%s.%s: field is missing", path, k))
+				continue
+			}
+			problems = append(problems, compareValue(path+"."+k, w[k], item)...)
+		}
+	case []any:
+		// The elements of the live array are compared against the
+		// first recorded element, which is representative of the type
+		// of the elements
+		if len(w) == 0 {
+			break
+		}
+		for idx, item := range got.([]any) {
+			problems = append(problems, compareValue(fmt.Sprintf("%s[%d]", path, idx), w[0], item)...)
+		}
+	}
+
+	return problems
+}
+
+// jsonType returns the name of the JSON type of the given value.
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
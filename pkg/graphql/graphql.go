@@ -0,0 +1,228 @@
+// Package graphql provides request matching and presentation of GraphQL
+// requests recorded in cassettes.
+//
+// GraphQL requests are usually POST requests with JSON bodies to a single
+// endpoint, so the default matcher, which compares bodies byte by byte, fails
+// to match requests, which differ only in the formatting of the query
+// document, or in the order of their variables. The [Matcher] of this package
+// compares the query documents by their canonical form, see [Normalize], and
+// the operation names, variables and extensions by their values.
+//
+// Requests are recognized in the following forms:
+//
+//   - POST requests with a JSON body, which is a request object, or a batch of
+//     request objects
+//   - POST requests with the "application/graphql" content type, where the
+//     body is the query document
+//   - GET requests with the "query", "operationName", "variables" and
+//     "extensions" URL query parameters
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// ErrNotGraphQL is returned, when a request is not a GraphQL request.
+var ErrNotGraphQL = errors.New("not a GraphQL request")
+
+// URL query parameters of GraphQL requests using the GET method
+var queryParams = []string{"query", "operationName", "variables", "extensions"}
+
+// Request is a GraphQL request.
+type Request struct {
+	// Query is the query document of the request
+	Query string `json:"query,omitempty"`
+
+	// OperationName is the name of the operation to execute, if the query
+	// document defines multiple operations
+	OperationName string `json:"operationName,omitempty"`
+
+	// Variables are the values of the variables of the operation
+	Variables map[string]any `json:"variables,omitempty"`
+
+	// Extensions are the protocol extensions of the request, e.g. the hash
+	// of a persisted query
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Parse returns the GraphQL requests of the given recorded request. It returns
+// multiple requests for batches, and [ErrNotGraphQL] if the request is not a
+// GraphQL request.
+func Parse(r cassette.Request) ([]*Request, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRequests(r.Method, u, r.Headers, r.Body)
+}
+
+// parseRequests returns the GraphQL requests of a request with the given
+// method, URL, headers and body.
+func parseRequests(method string, u *url.URL, header http.Header, body string) ([]*Request, error) {
+	if method == http.MethodGet {
+		query := u.Query()
+		if !query.Has("query") && !query.Has("extensions") {
+			return nil, ErrNotGraphQL
+		}
+
+		req := &Request{
+			Query:         query.Get("query"),
+			OperationName: query.Get("operationName"),
+		}
+
+		for name, v := range map[string]*map[string]any{"variables": &req.Variables, "extensions": &req.Extensions} {
+			if data := query.Get(name); data != "" {
+				if err := json.Unmarshal([]byte(data), v); err != nil {
+					return nil, fmt.Errorf("%w: invalid %s: %s", ErrNotGraphQL, name, err)
+				}
+			}
+		}
+
+		return []*Request{req}, nil
+	}
+
+	if method != http.MethodPost {
+		return nil, ErrNotGraphQL
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "application/graphql" {
+		return []*Request{{Query: body}}, nil
+	}
+
+	if mediaType != "" && mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil, ErrNotGraphQL
+	}
+
+	body = strings.TrimSpace(body)
+	requests := make([]*Request, 0)
+	switch {
+	case strings.HasPrefix(body, "{"):
+		req := &Request{}
+		if err := json.Unmarshal([]byte(body), req); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotGraphQL, err)
+		}
+		requests = append(requests, req)
+	case strings.HasPrefix(body, "["):
+		if err := json.Unmarshal([]byte(body), &requests); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotGraphQL, err)
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, ErrNotGraphQL
+	}
+
+	for _, req := range requests {
+		if req == nil || (req.Query == "" && req.Extensions == nil) {
+			return nil, ErrNotGraphQL
+		}
+	}
+
+	return requests, nil
+}
+
+// Operation returns the type and the name of the operation executed by the
+// request, e.g. "query" and "GetUser". The name is empty for anonymous
+// operations, and the type is empty, when the operation cannot be determined
+// from the query document, e.g. for persisted queries.
+func (r *Request) Operation() (string, string) {
+	doc, err := parseDocument(r.Query)
+	if err != nil {
+		return "", r.OperationName
+	}
+
+	for _, op := range doc.operations {
+		if r.OperationName == "" && len(doc.operations) == 1 {
+			return op.typ, op.name
+		}
+
+		if op.name != "" && op.name == r.OperationName {
+			return op.typ, op.name
+		}
+	}
+
+	return "", r.OperationName
+}
+
+// Label returns a label of the request, which consists of the type and name
+// of its operation, e.g. "query GetUser".
+func (r *Request) Label() string {
+	typ, name := r.Operation()
+	switch {
+	case typ == "" && name == "":
+		return "unknown operation"
+	case typ == "":
+		return name
+	case name == "":
+		return typ
+	default:
+		return typ + " " + name
+	}
+}
+
+// Equal returns true, if the given requests are semantically equal, i.e. if
+// their query documents have the same canonical form, and their operation
+// names, variables and extensions are equal.
+func (r *Request) Equal(other *Request) bool {
+	if r.OperationName != other.OperationName {
+		return false
+	}
+
+	if !valuesEqual(r.Variables, other.Variables) || !valuesEqual(r.Extensions, other.Extensions) {
+		return false
+	}
+
+	if r.Query == other.Query {
+		return true
+	}
+
+	x, err := Normalize(r.Query)
+	if err != nil {
+		return false
+	}
+
+	y, err := Normalize(other.Query)
+	if err != nil {
+		return false
+	}
+
+	return x == y
+}
+
+// valuesEqual returns true, if the given decoded JSON objects are equal, where
+// nil and empty objects are considered equal.
+func valuesEqual(x, y map[string]any) bool {
+	if len(x) == 0 && len(y) == 0 {
+		return true
+	}
+
+	return reflect.DeepEqual(x, y)
+}
+
+// Label returns a label of the given interaction, which consists of the
+// operations of its GraphQL requests, e.g. "query GetUser", or the method and
+// URL of its request, if it is not a GraphQL request.
+func Label(i *cassette.Interaction) string {
+	requests, err := Parse(i.Request)
+	if err != nil {
+		return i.Request.Method + " " + i.Request.URL
+	}
+
+	labels := make([]string, 0, len(requests))
+	for _, r := range requests {
+		labels = append(labels, r.Label())
+	}
+
+	return strings.Join(labels, ", ")
+}
@@ -0,0 +1,322 @@
+package graphql_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/graphql"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "shorthand",
+			query: "{ user { id } }",
+			want:  "query{user{id}}",
+		},
+		{
+			name: "whitespace, commas and comments",
+			query: `
+				# Fetches a user
+				query GetUser($id: ID!, $first: Int = 10) {
+					user(id: $id) {
+						name, # the display name
+						id
+						friends(first: $first, orderBy: {field: NAME, direction: ASC}) { id }
+					}
+				}`,
+			want: "query GetUser($first:Int=10 $id:ID!){user(id:$id){friends(first:$first orderBy:{direction:ASC field:NAME}){id} id name}}",
+		},
+		{
+			name:  "aliases",
+			query: "{ id: id, me: user { name } }",
+			want:  "query{id me:user{name}}",
+		},
+		{
+			name:  "fragments",
+			query: "query { node(id: 1) { ...UserFields ... on Post { title } } } fragment UserFields on User { name }",
+			want:  "fragment UserFields on User{name} query{node(id:1){...UserFields ...on Post{title}}}",
+		},
+		{
+			name:  "directives and lists keep their order",
+			query: `{ user @b @a(x: 1) { tags(in: ["b", "a"]) } }`,
+			want:  `query{user@b@a(x:1){tags(in:["b" "a"])}}`,
+		},
+		{
+			name:  "mutation root fields keep their order",
+			query: "mutation { deleteUser(id: 1) { name id } ... on Mutation { b a } ...Root } fragment Root on Mutation { d c { y x } }",
+			want:  "fragment Root on Mutation{d c{x y}} mutation{deleteUser(id:1){id name} ...on Mutation{b a} ...Root}",
+		},
+		{
+			name:  "strings",
+			query: "{ search(text: \"\\u0041<b>\", block: \"\"\"\n    first\n      second\n  \"\"\") }",
+			want:  `query{search(block:"first\n  second" text:"A<b>")}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := graphql.Normalize(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != test.want {
+				t.Fatalf("want %s, got %s", test.want, got)
+			}
+
+			again, err := graphql.Normalize(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if again != got {
+				t.Fatalf("canonical form is not stable: %s", again)
+			}
+		})
+	}
+}
+
+func TestNormalizeSyntaxError(t *testing.T) {
+	for _, query := range []string{"", "{ user {", "{ user(id: ) }", "{}", "type User { id: ID }", `{ user(name: "x) }`} {
+		_, err := graphql.Normalize(query)
+		if !errors.Is(err, graphql.ErrSyntax) {
+			t.Fatalf("expected ErrSyntax for %q, got %v", query, err)
+		}
+	}
+
+	_, err := graphql.Normalize("{\n  user(id: ) }")
+	if err == nil || !strings.HasSuffix(err.Error(), `unexpected ")" at line 2, column 12`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRequestEqual(t *testing.T) {
+	base := &graphql.Request{
+		Query:     "query GetUser($id: ID!) { user(id: $id) { id name } }",
+		Variables: map[string]any{"id": "1", "filter": map[string]any{"a": 1.0, "b": true}},
+	}
+
+	tests := []struct {
+		name  string
+		other *graphql.Request
+		want  bool
+	}{
+		{
+			name: "formatting and field order",
+			other: &graphql.Request{
+				Query:     "query GetUser($id: ID!) {\n  user(id: $id) {\n    name\n    id\n  }\n}",
+				Variables: map[string]any{"filter": map[string]any{"b": true, "a": 1.0}, "id": "1"},
+			},
+			want: true,
+		},
+		{
+			name: "different variables",
+			other: &graphql.Request{
+				Query:     base.Query,
+				Variables: map[string]any{"id": "2", "filter": map[string]any{"a": 1.0, "b": true}},
+			},
+		},
+		{
+			name: "different operation name",
+			other: &graphql.Request{
+				Query:         base.Query,
+				OperationName: "GetUser",
+				Variables:     base.Variables,
+			},
+		},
+		{
+			name: "different selection",
+			other: &graphql.Request{
+				Query:     "query GetUser($id: ID!) { user(id: $id) { id email } }",
+				Variables: base.Variables,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := base.Equal(test.other); got != test.want {
+				t.Fatalf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRequestEqualMutation(t *testing.T) {
+	x := &graphql.Request{Query: "mutation { deleteUser(id: 1) { id } createUser(id: 1) { id } }"}
+	y := &graphql.Request{Query: "mutation { createUser(id: 1) { id } deleteUser(id: 1) { id } }"}
+	if x.Equal(y) {
+		t.Fatal("mutations with a different order of root fields must not be equal")
+	}
+
+	z := &graphql.Request{Query: "mutation {\n  deleteUser(id: 1) { id }\n  createUser(id: 1) { id }\n}"}
+	if !x.Equal(z) {
+		t.Fatal("mutations with a different formatting must be equal")
+	}
+}
+
+func TestParseAndLabel(t *testing.T) {
+	header := http.Header{"Content-Type": {"application/json"}}
+	tests := []struct {
+		name    string
+		request cassette.Request
+		want    string
+	}{
+		{
+			name: "named operation",
+			request: cassette.Request{
+				Method:  http.MethodPost,
+				URL:     "http://example.com/graphql",
+				Headers: header,
+				Body:    `{"query": "mutation CreateUser { createUser { id } }"}`,
+			},
+			want: "mutation CreateUser",
+		},
+		{
+			name: "selected operation",
+			request: cassette.Request{
+				Method:  http.MethodPost,
+				URL:     "http://example.com/graphql",
+				Headers: header,
+				Body:    `{"query": "query A { a } query B { b }", "operationName": "B"}`,
+			},
+			want: "query B",
+		},
+		{
+			name: "batch",
+			request: cassette.Request{
+				Method:  http.MethodPost,
+				URL:     "http://example.com/graphql",
+				Headers: header,
+				Body:    `[{"query": "{ a }"}, {"query": "subscription OnEvent { event }"}]`,
+			},
+			want: "query, subscription OnEvent",
+		},
+		{
+			name: "persisted query",
+			request: cassette.Request{
+				Method: http.MethodGet,
+				URL:    "http://example.com/graphql?operationName=GetUser&extensions=" + url.QueryEscape(`{"persistedQuery":{"version":1}}`),
+			},
+			want: "GetUser",
+		},
+		{
+			name: "graphql content type",
+			request: cassette.Request{
+				Method:  http.MethodPost,
+				URL:     "http://example.com/graphql",
+				Headers: http.Header{"Content-Type": {"application/graphql"}},
+				Body:    "query Users { users { id } }",
+			},
+			want: "query Users",
+		},
+		{
+			name: "not graphql",
+			request: cassette.Request{
+				Method:  http.MethodPost,
+				URL:     "http://example.com/search",
+				Headers: header,
+				Body:    `{"query": {"match": "all"}}`,
+			},
+			want: "POST http://example.com/search",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := graphql.Label(&cassette.Interaction{Request: test.request})
+			if got != test.want {
+				t.Fatalf("want %q, got %q", test.want, got)
+			}
+		})
+	}
+
+	_, err := graphql.Parse(tests[len(tests)-1].request)
+	if !errors.Is(err, graphql.ErrNotGraphQL) {
+		t.Fatalf("expected ErrNotGraphQL, got %v", err)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"data": {"echo": %q}}`, body)
+	}))
+	defer server.Close()
+
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassPath := filepath.Join(dir, "graphql")
+
+	post := func(t *testing.T, client *http.Client, body string) (string, error) {
+		t.Helper()
+
+		resp, err := client.Post(server.URL+"/graphql", "application/json", strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+
+		return string(data), err
+	}
+
+	recorded := `{"query": "query GetUser($id: ID!) { user(id: $id) { id name } }", "variables": {"id": "1", "page": 2}}`
+	rec, err := recorder.New(cassPath, recorder.WithMode(recorder.ModeRecordOnly), recorder.WithMatcher(graphql.Matcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := post(t, rec.GetDefaultClient(), recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly), recorder.WithMatcher(graphql.Matcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	// The same request with a different formatting, field order and
+	// variable order
+	got, err := post(t, rec.GetDefaultClient(), `{
+		"variables": {"page": 2, "id": "1"},
+		"query": "query GetUser($id: ID!) {\n  user(id: $id) {\n    name\n    id\n  }\n}"
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want {
+		t.Fatalf("want response %s, got %s", want, got)
+	}
+
+	_, err = post(t, rec.GetDefaultClient(), `{"query": "query GetUser($id: ID!) { user(id: $id) { id name } }", "variables": {"id": "2", "page": 2}}`)
+	if !errors.Is(err, cassette.ErrInteractionNotFound) {
+		t.Fatalf("expected ErrInteractionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package graphql
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// Matcher is the GraphQL matcher with the default options.
+var Matcher = NewMatcher()
+
+// NewMatcher returns a [cassette.MatcherFunc], which matches GraphQL requests
+// semantically. The GraphQL requests are compared using [Request.Equal], and
+// the rest of the requests, e.g. the method, URL and headers, are compared by
+// the default matcher, which is configured using the given options. Requests,
+// which are not GraphQL requests, are matched by the default matcher only.
+func NewMatcher(opts ...cassette.DefaultMatcherOption) cassette.MatcherFunc {
+	defaultMatcher := cassette.NewDefaultMatcher(opts...)
+
+	m := func(r *http.Request, i cassette.Request) bool {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(r.Body)
+			if err != nil {
+				return false
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		actual, actualErr := parseRequests(r.Method, r.URL, r.Header, string(body))
+		recorded, recordedErr := Parse(i)
+		switch {
+		case actualErr != nil && recordedErr != nil:
+			return defaultMatcher(r, i)
+		case actualErr != nil || recordedErr != nil:
+			return false
+		case len(actual) != len(recorded):
+			return false
+		}
+
+		for n := range actual {
+			if !actual[n].Equal(recorded[n]) {
+				return false
+			}
+		}
+
+		// The GraphQL requests are removed before the rest of the requests
+		// are compared
+		stripped := r.Clone(r.Context())
+		stripped.Body = http.NoBody
+		stripped.ContentLength = 0
+		i.Body = ""
+		i.ContentLength = 0
+
+		if r.Method == http.MethodGet {
+			stripped.URL.RawQuery = stripQueryParams(r.URL.Query())
+			if u, err := url.Parse(i.URL); err == nil {
+				u.RawQuery = stripQueryParams(u.Query())
+				i.URL = u.String()
+			}
+		}
+
+		return defaultMatcher(stripped, i)
+	}
+
+	return m
+}
+
+// stripQueryParams returns the encoded URL query without the parameters of
+// GraphQL requests.
+func stripQueryParams(query url.Values) string {
+	for _, param := range queryParams {
+		query.Del(param)
+	}
+
+	return query.Encode()
+}
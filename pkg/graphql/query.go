@@ -0,0 +1,852 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrSyntax is returned, when a query document cannot be parsed.
+var ErrSyntax = errors.New("graphql syntax error")
+
+// Operation types of GraphQL operations
+const (
+	OperationQuery        = "query"
+	OperationMutation     = "mutation"
+	OperationSubscription = "subscription"
+)
+
+// Normalize returns the canonical form of the given query document, so that
+// documents, which differ only in insignificant ways, have the same canonical
+// form.
+//
+// The canonical form is compact, i.e. it contains no comments, commas or
+// redundant whitespace, strings are printed as regular strings, and the query
+// shorthand is printed as a query operation. The definitions of the document,
+// the selections of selection sets, the arguments of fields and directives,
+// the fields of input objects and the variable definitions of operations are
+// sorted, since their order does not change the result of an operation, apart
+// from the order of the fields of the response. The root selections of
+// mutations, including those of the fragments spread at the root, keep their
+// order, since the root fields of mutations are executed serially. Aliases,
+// which equal the name of their field, are removed. The order of directives
+// and list values is preserved.
+func Normalize(query string) (string, error) {
+	doc, err := parseDocument(query)
+	if err != nil {
+		return "", err
+	}
+
+	return doc.canonical, nil
+}
+
+// operation is an operation defined by a query document.
+type operation struct {
+	// typ is the type of the operation, e.g. "query"
+	typ string
+
+	// name is the name of the operation, if any
+	name string
+}
+
+// document is a parsed query document.
+type document struct {
+	// canonical is the canonical form of the document
+	canonical string
+
+	// operations are the operations of the document in the order they are
+	// defined
+	operations []operation
+}
+
+// tokenKind is the kind of a lexical token.
+type tokenKind int
+
+// Kinds of lexical tokens
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenNumber
+	tokenString
+)
+
+// token is a lexical token of a query document.
+type token struct {
+	// kind is the kind of the token
+	kind tokenKind
+
+	// value is the text of the token, or the value of strings
+	value string
+
+	// pos is the offset of the token in the document
+	pos int
+}
+
+// parser is a recursive descent parser of executable query documents, which
+// prints the canonical form of the parsed definitions.
+type parser struct {
+	// src is the query document
+	src string
+
+	// pos is the offset of the next token in the document
+	pos int
+
+	// tok is the current token
+	tok token
+
+	// spreads are the names of the fragments spread in ordered selection
+	// sets, whose selections keep their order as well
+	spreads []string
+}
+
+// parseDocument parses the given query document.
+func parseDocument(src string) (*document, error) {
+	p := &parser{src: strings.TrimPrefix(src, "\ufeff")}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{}
+	definitions := make([]string, 0)
+	// fragments maps the names of fragment definitions to their offsets in
+	// the document and their indices in definitions
+	fragments := make(map[string][2]int)
+	for p.tok.kind != tokenEOF {
+		start := p.tok.pos
+		def, op, err := p.definition()
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			fragments[strings.Fields(def)[1]] = [2]int{start, len(definitions)}
+		}
+		definitions = append(definitions, def)
+		if op != nil {
+			doc.operations = append(doc.operations, *op)
+		}
+	}
+
+	if len(definitions) == 0 {
+		return nil, fmt.Errorf("%w: empty document", ErrSyntax)
+	}
+
+	// The fragments spread at the root of mutations are parsed again, so
+	// that their selections keep their order
+	ordered := make(map[string]bool)
+	for len(p.spreads) > 0 {
+		name := p.spreads[0]
+		p.spreads = p.spreads[1:]
+		fragment, ok := fragments[name]
+		if !ok || ordered[name] {
+			continue
+		}
+		ordered[name] = true
+
+		q := &parser{src: p.src, pos: fragment[0]}
+		if err := q.next(); err != nil {
+			return nil, err
+		}
+		def, err := q.fragmentDefinition(true)
+		if err != nil {
+			return nil, err
+		}
+		definitions[fragment[1]] = def
+		p.spreads = append(p.spreads, q.spreads...)
+	}
+
+	sort.Strings(definitions)
+	doc.canonical = strings.Join(definitions, " ")
+
+	return doc, nil
+}
+
+// errorf returns a syntax error at the current token.
+func (p *parser) errorf(format string, args ...any) error {
+	line, column := 1, 1
+	for _, r := range p.src[:p.tok.pos] {
+		if r == '\n' {
+			line += 1
+			column = 1
+		} else {
+			column += 1
+		}
+	}
+
+	return fmt.Errorf("%w: %s at line %d, column %d", ErrSyntax, fmt.Sprintf(format, args...), line, column)
+}
+
+// unexpected returns the syntax error of an unexpected token.
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return p.errorf("unexpected end of document")
+	}
+
+	return p.errorf("unexpected %q", p.src[p.tok.pos:p.pos])
+}
+
+// next advances to the next token.
+func (p *parser) next() error {
+	// Skip ignored tokens, i.e. whitespace, line terminators, commas and
+	// comments
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos += 1
+			continue
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' && p.src[p.pos] != '\r' {
+				p.pos += 1
+			}
+			continue
+		}
+		break
+	}
+
+	start := p.pos
+	p.tok = token{kind: tokenEOF, pos: start}
+	if p.pos >= len(p.src) {
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokenPunctuator, value: "...", pos: start}
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		p.pos += 1
+		p.tok = token{kind: tokenPunctuator, value: string(c), pos: start}
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos += 1
+		}
+		p.tok = token{kind: tokenName, value: p.src[start:p.pos], pos: start}
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	default:
+		_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.pos += size
+		return p.unexpected()
+	}
+
+	return nil
+}
+
+// number scans a number token.
+func (p *parser) number() error {
+	start := p.pos
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+			p.pos += 1
+			n += 1
+		}
+		return n
+	}
+
+	if p.src[p.pos] == '-' {
+		p.pos += 1
+	}
+	ok := digits() > 0
+	if ok && p.pos < len(p.src) && p.src[p.pos] == '.' {
+		p.pos += 1
+		ok = digits() > 0
+	}
+	if ok && p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+		p.pos += 1
+		if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+			p.pos += 1
+		}
+		ok = digits() > 0
+	}
+
+	p.tok = token{kind: tokenNumber, value: p.src[start:p.pos], pos: start}
+	if !ok {
+		return p.errorf("invalid number %q", p.tok.value)
+	}
+
+	return nil
+}
+
+// string scans a string token, and decodes its value.
+func (p *parser) string() error {
+	start := p.pos
+	p.tok = token{kind: tokenString, pos: start}
+
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		p.pos += 3
+		var raw strings.Builder
+		for {
+			switch {
+			case p.pos >= len(p.src):
+				return p.errorf("unterminated string")
+			case strings.HasPrefix(p.src[p.pos:], `\"""`):
+				raw.WriteString(`"""`)
+				p.pos += 4
+			case strings.HasPrefix(p.src[p.pos:], `"""`):
+				p.pos += 3
+				p.tok.value = blockStringValue(raw.String())
+				return nil
+			default:
+				raw.WriteByte(p.src[p.pos])
+				p.pos += 1
+			}
+		}
+	}
+
+	p.pos += 1
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' || p.src[p.pos] == '\r' {
+			return p.errorf("unterminated string")
+		}
+
+		c := p.src[p.pos]
+		p.pos += 1
+		if c == '"' {
+			break
+		}
+		if c == '\\' && p.pos < len(p.src) {
+			p.pos += 1
+		}
+	}
+
+	// The escape sequences of strings are the ones of JSON
+	if err := json.Unmarshal([]byte(p.src[start:p.pos]), &p.tok.value); err != nil {
+		return p.errorf("invalid string")
+	}
+
+	return nil
+}
+
+// blockStringValue returns the value of a block string with the given raw
+// content, i.e. without the common indentation, and leading and trailing blank
+// lines.
+func blockStringValue(raw string) string {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	lines := strings.Split(strings.ReplaceAll(raw, "\r", "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for n := 1; n < len(lines); n++ {
+			if len(lines[n]) >= indent {
+				lines[n] = lines[n][indent:]
+			} else {
+				lines[n] = strings.TrimLeft(lines[n], " \t")
+			}
+		}
+	}
+
+	blank := func(line string) bool {
+		return strings.TrimLeft(line, " \t") == ""
+	}
+	for len(lines) > 0 && blank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// isLetter returns true, if the given byte is an ASCII letter.
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isDigit returns true, if the given byte is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// peek returns true, if the current token is the given punctuator.
+func (p *parser) peek(punctuator string) bool {
+	return p.tok.kind == tokenPunctuator && p.tok.value == punctuator
+}
+
+// skip advances to the next token, if the current token is the given
+// punctuator, and returns true, if it was skipped.
+func (p *parser) skip(punctuator string) (bool, error) {
+	if !p.peek(punctuator) {
+		return false, nil
+	}
+
+	return true, p.next()
+}
+
+// expect advances past the given punctuator, or returns a syntax error.
+func (p *parser) expect(punctuator string) error {
+	if !p.peek(punctuator) {
+		return p.unexpected()
+	}
+
+	return p.next()
+}
+
+// name returns the current name token and advances to the next token.
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+
+	name := p.tok.value
+
+	return name, p.next()
+}
+
+// keyword advances past the given name, or returns a syntax error.
+func (p *parser) keyword(keyword string) error {
+	if p.tok.kind != tokenName || p.tok.value != keyword {
+		return p.unexpected()
+	}
+
+	return p.next()
+}
+
+// definition parses an operation or fragment definition.
+func (p *parser) definition() (string, *operation, error) {
+	if p.peek("{") {
+		// The query shorthand
+		selections, err := p.selectionSet(false)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return OperationQuery + selections, &operation{typ: OperationQuery}, nil
+	}
+
+	if p.tok.kind != tokenName {
+		return "", nil, p.unexpected()
+	}
+
+	switch p.tok.value {
+	case OperationQuery, OperationMutation, OperationSubscription:
+		return p.operationDefinition()
+	case "fragment":
+		def, err := p.fragmentDefinition(false)
+		return def, nil, err
+	default:
+		return "", nil, p.unexpected()
+	}
+}
+
+// operationDefinition parses an operation definition.
+func (p *parser) operationDefinition() (string, *operation, error) {
+	op := &operation{typ: p.tok.value}
+	if err := p.next(); err != nil {
+		return "", nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(op.typ)
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		b.WriteString(" " + op.name)
+		if err := p.next(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if p.peek("(") {
+		variables, err := p.variableDefinitions()
+		if err != nil {
+			return "", nil, err
+		}
+		b.WriteString(variables)
+	}
+
+	directives, err := p.directives()
+	if err != nil {
+		return "", nil, err
+	}
+	b.WriteString(directives)
+
+	// The root fields of mutations are executed serially
+	selections, err := p.selectionSet(op.typ == OperationMutation)
+	if err != nil {
+		return "", nil, err
+	}
+	b.WriteString(selections)
+
+	return b.String(), op, nil
+}
+
+// fragmentDefinition parses a fragment definition. The selections of ordered
+// fragments keep their order.
+func (p *parser) fragmentDefinition(ordered bool) (string, error) {
+	if err := p.keyword("fragment"); err != nil {
+		return "", err
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.keyword("on"); err != nil {
+		return "", err
+	}
+
+	typ, err := p.name()
+	if err != nil {
+		return "", err
+	}
+
+	directives, err := p.directives()
+	if err != nil {
+		return "", err
+	}
+
+	selections, err := p.selectionSet(ordered)
+	if err != nil {
+		return "", err
+	}
+
+	return "fragment " + name + " on " + typ + directives + selections, nil
+}
+
+// variableDefinitions parses the variable definitions of an operation.
+func (p *parser) variableDefinitions() (string, error) {
+	return p.sortedList("(", ")", false, func() (string, error) {
+		if err := p.expect("$"); err != nil {
+			return "", err
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+
+		if err := p.expect(":"); err != nil {
+			return "", err
+		}
+
+		typ, err := p.typeReference()
+		if err != nil {
+			return "", err
+		}
+
+		def := "$" + name + ":" + typ
+		if ok, err := p.skip("="); err != nil {
+			return "", err
+		} else if ok {
+			value, err := p.value(true)
+			if err != nil {
+				return "", err
+			}
+			def += "=" + value
+		}
+
+		directives, err := p.directives()
+		if err != nil {
+			return "", err
+		}
+
+		return def + directives, nil
+	})
+}
+
+// typeReference parses a type reference, e.g. "[ID!]!".
+func (p *parser) typeReference() (string, error) {
+	var typ string
+	if ok, err := p.skip("["); err != nil {
+		return "", err
+	} else if ok {
+		elem, err := p.typeReference()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect("]"); err != nil {
+			return "", err
+		}
+		typ = "[" + elem + "]"
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+
+	if ok, err := p.skip("!"); err != nil {
+		return "", err
+	} else if ok {
+		typ += "!"
+	}
+
+	return typ, nil
+}
+
+// selectionSet parses a selection set. The selections of ordered selection
+// sets keep their order.
+func (p *parser) selectionSet(ordered bool) (string, error) {
+	return p.list("{", "}", false, !ordered, func() (string, error) {
+		return p.selection(ordered)
+	})
+}
+
+// selection parses a field, fragment spread or inline fragment. The
+// selections of fragments within ordered selection sets keep their order,
+// while those of fields are sorted.
+func (p *parser) selection(ordered bool) (string, error) {
+	if ok, err := p.skip("..."); err != nil {
+		return "", err
+	} else if ok {
+		return p.fragment(ordered)
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return "", err
+	}
+
+	field := name
+	if ok, err := p.skip(":"); err != nil {
+		return "", err
+	} else if ok {
+		alias := name
+		if name, err = p.name(); err != nil {
+			return "", err
+		}
+
+		field = name
+		if alias != name {
+			field = alias + ":" + name
+		}
+	}
+
+	if p.peek("(") {
+		arguments, err := p.arguments(false)
+		if err != nil {
+			return "", err
+		}
+		field += arguments
+	}
+
+	directives, err := p.directives()
+	if err != nil {
+		return "", err
+	}
+	field += directives
+
+	if p.peek("{") {
+		selections, err := p.selectionSet(false)
+		if err != nil {
+			return "", err
+		}
+		field += selections
+	}
+
+	return field, nil
+}
+
+// fragment parses a fragment spread or an inline fragment following the
+// spread punctuator.
+func (p *parser) fragment(ordered bool) (string, error) {
+	fragment := "..."
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		// Fragment spread
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+
+		directives, err := p.directives()
+		if err != nil {
+			return "", err
+		}
+
+		if ordered {
+			p.spreads = append(p.spreads, name)
+		}
+
+		return fragment + name + directives, nil
+	}
+
+	if p.tok.kind == tokenName {
+		if err := p.keyword("on"); err != nil {
+			return "", err
+		}
+
+		typ, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		fragment += "on " + typ
+	}
+
+	directives, err := p.directives()
+	if err != nil {
+		return "", err
+	}
+
+	selections, err := p.selectionSet(ordered)
+	if err != nil {
+		return "", err
+	}
+
+	return fragment + directives + selections, nil
+}
+
+// directives parses the directives at the current position, if any. The
+// order of directives is preserved, since repeatable directives may depend on
+// it.
+func (p *parser) directives() (string, error) {
+	var b strings.Builder
+	for p.peek("@") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("@" + name)
+
+		if p.peek("(") {
+			arguments, err := p.arguments(false)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(arguments)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// arguments parses the arguments of a field or directive.
+func (p *parser) arguments(constant bool) (string, error) {
+	return p.sortedList("(", ")", false, func() (string, error) {
+		return p.objectField(constant)
+	})
+}
+
+// objectField parses an argument, or a field of an input object.
+func (p *parser) objectField(constant bool) (string, error) {
+	name, err := p.name()
+	if err != nil {
+		return "", err
+	}
+
+	if err := p.expect(":"); err != nil {
+		return "", err
+	}
+
+	value, err := p.value(constant)
+	if err != nil {
+		return "", err
+	}
+
+	return name + ":" + value, nil
+}
+
+// value parses a value. Constant values, e.g. the default values of
+// variables, must not contain variables.
+func (p *parser) value(constant bool) (string, error) {
+	switch p.tok.kind {
+	case tokenNumber, tokenName:
+		value := p.tok.value
+		return value, p.next()
+	case tokenString:
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(p.tok.value); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), p.next()
+	}
+
+	switch {
+	case p.peek("$") && !constant:
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		name, err := p.name()
+		if err != nil {
+			return "", err
+		}
+		return "$" + name, nil
+	case p.peek("["):
+		// The order of list values is significant
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		values := make([]string, 0)
+		for !p.peek("]") {
+			value, err := p.value(constant)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return "[" + strings.Join(values, " ") + "]", p.next()
+	case p.peek("{"):
+		return p.sortedList("{", "}", true, func() (string, error) {
+			return p.objectField(constant)
+		})
+	default:
+		return "", p.unexpected()
+	}
+}
+
+// sortedList parses a list of items enclosed in the given punctuators, and
+// returns the sorted items.
+func (p *parser) sortedList(open, close string, allowEmpty bool, item func() (string, error)) (string, error) {
+	return p.list(open, close, allowEmpty, true, item)
+}
+
+// list parses a list of items enclosed in the given punctuators, and returns
+// the items, which are sorted, if requested.
+func (p *parser) list(open, close string, allowEmpty, sorted bool, item func() (string, error)) (string, error) {
+	if err := p.expect(open); err != nil {
+		return "", err
+	}
+
+	items := make([]string, 0)
+	for !p.peek(close) {
+		if p.tok.kind == tokenEOF {
+			return "", p.unexpected()
+		}
+
+		s, err := item()
+		if err != nil {
+			return "", err
+		}
+		items = append(items, s)
+	}
+
+	if len(items) == 0 && !allowEmpty {
+		return "", p.unexpected()
+	}
+
+	if err := p.next(); err != nil {
+		return "", err
+	}
+
+	if sorted {
+		sort.Strings(items)
+	}
+
+	return open + strings.Join(items, " ") + close, nil
+}
@@ -0,0 +1,350 @@
+// Package grpcrecorder provides gRPC client interceptors, which record gRPC
+// calls into a cassette and replay them without a server.
+//
+// The interceptors represent each call as an HTTP/2 request and response, so
+// that calls are recorded and replayed by a [recorder.Recorder], and all of its
+// modes, hooks and options apply to them. The request is a POST request to the
+// full method of the call, e.g. "/helloworld.Greeter/SayHello", with the
+// outgoing metadata as headers, and the response has the header metadata as
+// headers, and the trailer metadata along with the status of the call as
+// trailers. The request and response messages are the bodies, one message per
+// line, encoded in the protobuf JSON format when the descriptors of all the
+// types they contain are available, and in the protobuf wire format encoded as
+// base64 otherwise.
+//
+// Streams are recorded once they are finished, i.e. once receiving a message
+// returns an error, or the single response of a client streaming call was
+// received.
+package grpcrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
+)
+
+// ErrMessageMismatch is returned by replayed streams, when a sent message does
+// not match the recorded one.
+var ErrMessageMismatch = errors.New("sent message does not match the recorded one")
+
+// errRecordStream is returned by the transport, when a stream is about to be
+// recorded, so that the interceptor starts the real stream.
+var errRecordStream = errors.New("stream needs to be recorded")
+
+// Recorder records and replays gRPC calls using a [recorder.Recorder].
+type Recorder struct {
+	// rec is the recorder of the calls
+	rec *recorder.Recorder
+}
+
+// New creates a new [Recorder] for gRPC calls, which is configured using the
+// given [recorder.Option] options. The calls are matched using [Matcher],
+// unless another matcher is configured, and the real transport of the recorder
+// is always the one performing the gRPC calls.
+func New(cassetteName string, opts ...recorder.Option) (*Recorder, error) {
+	opts = append([]recorder.Option{recorder.WithMatcher(Matcher)}, opts...)
+	opts = append(opts, recorder.WithRealTransport(transport{}))
+
+	rec, err := recorder.New(cassetteName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{
+		rec: rec,
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode of the recorder.
+func (r *Recorder) Mode() recorder.Mode {
+	return r.rec.Mode()
+}
+
+// Stop stops the recorder and saves the recorded calls, if running in one of
+// the recording modes.
+func (r *Recorder) Stop() error {
+	return r.rec.Stop()
+}
+
+// DialOptions returns the options, which configure a client connection to use
+// the interceptors of the recorder.
+func (r *Recorder) DialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(r.StreamClientInterceptor()),
+	}
+
+	return opts
+}
+
+// callKey is the context key of the call, which is performed by a request.
+type callKey struct{}
+
+// call is a gRPC call, which is performed by a request.
+type call struct {
+	// messages are the request messages sent so far
+	messages []any
+
+	// stream is true for streams
+	stream bool
+
+	// invoke performs a unary call
+	invoke func(r *http.Request) (*http.Response, error)
+
+	// response is the response of a finished stream, which is being
+	// recorded
+	response *http.Response
+}
+
+// callFrom returns the call of the given request, if any.
+func callFrom(r *http.Request) *call {
+	c, _ := r.Context().Value(callKey{}).(*call)
+
+	return c
+}
+
+// transport is the real transport of the recorder, which performs the calls.
+type transport struct{}
+
+// RoundTrip implements the [http.RoundTripper] interface.
+func (transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c := callFrom(r)
+	if c == nil {
+		return nil, fmt.Errorf("%s %s is not a gRPC call", r.Method, r.URL)
+	}
+
+	// The recorder records the body of the request as it is read
+	if r.Body != nil {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case c.response != nil:
+		return c.response, nil
+	case c.stream:
+		return nil, errRecordStream
+	default:
+		return c.invoke(r)
+	}
+}
+
+// Matcher is the default [cassette.MatcherFunc] of the [Recorder]. It matches
+// calls by their full method, metadata and request message. Streams are matched
+// when they are created, i.e. without their messages, and replayed streams
+// verify each sent message against the recorded one instead.
+func Matcher(r *http.Request, i cassette.Request) bool {
+	return match(r, i, nil)
+}
+
+// NewMatcher returns a [Matcher], which ignores the given metadata keys, e.g.
+// metadata, which is removed from the cassette using hooks.
+func NewMatcher(ignoreMetadata ...string) cassette.MatcherFunc {
+	return func(r *http.Request, i cassette.Request) bool {
+		return match(r, i, ignoreMetadata)
+	}
+}
+
+// match is a predicate which matches the request of a call against a recorded
+// request, while ignoring the given metadata keys.
+func match(r *http.Request, i cassette.Request, ignoreMetadata []string) bool {
+	c := callFrom(r)
+	if c == nil || c.response != nil {
+		// Finished streams are always recorded
+		return false
+	}
+
+	if r.Method != i.Method || r.URL.String() != i.URL {
+		return false
+	}
+
+	header := r.Header.Clone()
+	recorded := i.Headers.Clone()
+	for _, key := range ignoreMetadata {
+		header.Del(key)
+		recorded.Del(key)
+	}
+
+	if !reflect.DeepEqual(header, recorded) {
+		return false
+	}
+
+	if c.stream {
+		return true
+	}
+
+	messages := splitMessages(i.Body)
+
+	return len(messages) == 1 && len(c.messages) == 1 && messageEqual(messages[0], c.messages[0])
+}
+
+// newRequest creates the request, which represents the call of the given
+// method on the server with the given authority.
+func newRequest(ctx context.Context, c *call, host, method string) (*http.Request, error) {
+	messages := make([]string, 0, len(c.messages))
+	for _, m := range c.messages {
+		data, err := encodeMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, data)
+	}
+
+	u := &url.URL{Scheme: "http", Host: host, Path: method}
+	body := joinMessages(messages)
+	r, err := http.NewRequestWithContext(context.WithValue(ctx, callKey{}, c), http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Proto = "HTTP/2.0"
+	r.ProtoMajor = 2
+	r.ProtoMinor = 0
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	r.Header = headerFromMD(md)
+	r.Header.Set("Content-Type", "application/grpc")
+
+	return r, nil
+}
+
+// newResponse creates the response, which represents the result of a call.
+func newResponse(r *http.Request, messages []any, header, trailer metadata.MD, err error) (*http.Response, error) {
+	encoded := make([]string, 0, len(messages))
+	for _, m := range messages {
+		data, err := encodeMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, data)
+	}
+
+	st := status.Convert(err)
+	if errors.Is(err, io.EOF) {
+		st = status.New(codes.OK, "")
+	}
+
+	trailers, err := trailerFromStatus(trailer, st)
+	if err != nil {
+		return nil, err
+	}
+
+	body := joinMessages(encoded)
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		ProtoMinor:    0,
+		Header:        headerFromMD(header),
+		Trailer:       trailers,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+
+	return resp, nil
+}
+
+// authority returns the authority of the given target, e.g. "localhost:50051"
+// for "dns:///localhost:50051".
+func authority(target string) string {
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" && u.Opaque == "" {
+		target = u.Host
+		if target == "" {
+			target = strings.TrimPrefix(u.Path, "/")
+		}
+	}
+
+	if target == "" || strings.Contains(target, "/") {
+		return "localhost"
+	}
+
+	return target
+}
+
+// UnaryClientInterceptor returns the interceptor, which records and replays
+// unary calls.
+func (r *Recorder) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c := &call{
+			messages: []any{req},
+		}
+
+		c.invoke = func(hr *http.Request) (*http.Response, error) {
+			var header, trailer metadata.MD
+			// Copy the options, so that the slice of the caller is
+			// never written to
+			callOpts := make([]grpc.CallOption, 0, len(opts)+2)
+			callOpts = append(callOpts, opts...)
+			callOpts = append(callOpts, grpc.Header(&header), grpc.Trailer(&trailer))
+			err := invoker(hr.Context(), method, req, reply, cc, callOpts...)
+
+			messages := make([]any, 0)
+			if err == nil {
+				messages = append(messages, reply)
+			}
+
+			return newResponse(hr, messages, header, trailer, err)
+		}
+
+		hr, err := newRequest(ctx, c, authority(cc.Target()), method)
+		if err != nil {
+			return err
+		}
+
+		resp, err := r.rec.RoundTrip(hr)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		st, err := statusFromTrailer(resp.Trailer)
+		if err != nil {
+			return err
+		}
+
+		header := mdFromHeader(resp.Header)
+		trailer := mdFromHeader(resp.Trailer)
+		for _, opt := range opts {
+			switch o := opt.(type) {
+			case grpc.HeaderCallOption:
+				*o.HeaderAddr = header
+			case grpc.TrailerCallOption:
+				*o.TrailerAddr = trailer
+			}
+		}
+
+		if err := st.Err(); err != nil {
+			return err
+		}
+
+		messages := splitMessages(string(body))
+		if len(messages) != 1 {
+			return fmt.Errorf("expected a single response message for %s, got %d", method, len(messages))
+		}
+
+		return decodeMessage(messages[0], reply)
+	}
+}
@@ -0,0 +1,370 @@
+package grpcrecorder_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/grpcrecorder"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
+)
+
+// testServer is a gRPC test service, which echoes the requests.
+type testServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (s *testServer) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs("x-user", strings.Join(md.Get("x-user"), ",")))
+	grpc.SetTrailer(ctx, metadata.Pairs("x-trailer", "done", "x-checksum-bin", "\x00\x01"))
+
+	if req.ResponseStatus != nil {
+		st, err := status.New(codes.Code(req.ResponseStatus.Code), req.ResponseStatus.Message).
+			WithDetails(&errdetails.ErrorInfo{Reason: "TEST"})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+
+	return &testpb.SimpleResponse{Payload: req.Payload}, nil
+}
+
+func (s *testServer) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, p := range req.ResponseParameters {
+		resp := &testpb.StreamingOutputCallResponse{
+			Payload: &testpb.Payload{Body: []byte(strings.Repeat("a", int(p.Size)))},
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+	stream.SetTrailer(metadata.Pairs("x-count", fmt.Sprint(len(req.ResponseParameters))))
+
+	return nil
+}
+
+func (s *testServer) StreamingInputCall(stream testpb.TestService_StreamingInputCallServer) error {
+	var size int32
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&testpb.StreamingInputCallResponse{AggregatedPayloadSize: size})
+		}
+		if err != nil {
+			return err
+		}
+		size += int32(len(req.Payload.GetBody()))
+	}
+}
+
+func (s *testServer) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return status.Error(codes.Aborted, "client closed")
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.Payload}); err != nil {
+			return err
+		}
+	}
+}
+
+// newTestServer starts the test service and returns the dialer of the in-memory
+// listener of the server.
+func newTestServer(t *testing.T) func(context.Context, string) (net.Conn, error) {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, &testServer{})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	}
+}
+
+// newTestClient creates a client of the test service, which uses the
+// interceptors of the recorder.
+func newTestClient(t *testing.T, rec *grpcrecorder.Recorder, dialer func(context.Context, string) (net.Conn, error)) testpb.TestServiceClient {
+	t.Helper()
+
+	opts := append(
+		rec.DialOptions(),
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return testpb.NewTestServiceClient(conn)
+}
+
+// exercise performs calls of all kinds and returns a transcript of their
+// results.
+func exercise(t *testing.T, client testpb.TestServiceClient) []string {
+	t.Helper()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user", "ava")
+	transcript := make([]string, 0)
+	logf := func(format string, args ...any) {
+		transcript = append(transcript, fmt.Sprintf(format, args...))
+	}
+
+	// Unary call with header and trailer metadata
+	var header, trailer metadata.MD
+	resp, err := client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("hello")}},
+		grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logf("unary: %s header=%v trailer=%v %q", resp.Payload.Body, header.Get("x-user"), trailer.Get("x-trailer"), trailer.Get("x-checksum-bin"))
+
+	// Unary call with an error status and details
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{
+		ResponseStatus: &testpb.EchoStatus{Code: int32(codes.NotFound), Message: "no such user"},
+	})
+	st := status.Convert(err)
+	logf("unary error: %s %q details=%v", st.Code(), st.Message(), len(st.Details()))
+
+	// Server streaming
+	out, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{
+		ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		resp, err := out.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		logf("server stream: %s", resp.Payload.Body)
+	}
+	logf("server stream trailer: %v", out.Trailer().Get("x-count"))
+
+	// Client streaming
+	in, err := client.StreamingInputCall(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"a", "bb"} {
+		if err := in.Send(&testpb.StreamingInputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sum, err := in.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logf("client stream: %d", sum.AggregatedPayloadSize)
+
+	// Bidirectional streaming, which ends with an error status
+	duplex, err := client.FullDuplexCall(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"ping", "pong"} {
+		if err := duplex.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := duplex.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logf("bidi stream: %s", resp.Payload.Body)
+	}
+	if err := duplex.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = duplex.Recv()
+	logf("bidi stream end: %s", status.Code(err))
+
+	return transcript
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassPath := filepath.Join(dir, "grpc")
+
+	// Record the calls against the real server
+	rec, err := grpcrecorder.New(cassPath, recorder.WithMode(recorder.ModeRecordOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorded := exercise(t, newTestClient(t, rec, newTestServer(t)))
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`unary: hello header=[ava] trailer=[done] ["\x00\x01"]`,
+		`unary error: NotFound "no such user" details=1`,
+		`server stream: a`,
+		`server stream: aa`,
+		`server stream: aaa`,
+		`server stream trailer: [3]`,
+		`client stream: 3`,
+		`bidi stream: ping`,
+		`bidi stream: pong`,
+		`bidi stream end: Aborted`,
+	}
+	if strings.Join(recorded, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected transcript:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(recorded, "\n"))
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 5 {
+		t.Fatalf("expected 5 interactions, got %d", len(c.Interactions))
+	}
+
+	unary := c.Interactions[0]
+	if unary.Request.URL != "http://bufnet/grpc.testing.TestService/UnaryCall" {
+		t.Fatalf("unexpected request URL %s", unary.Request.URL)
+	}
+	if unary.Request.Body != `{"payload":{"body":"aGVsbG8="}}` {
+		t.Fatalf("unexpected request body %s", unary.Request.Body)
+	}
+	if unary.Request.Headers.Get("X-User") != "ava" {
+		t.Fatalf("unexpected request metadata %v", unary.Request.Headers)
+	}
+	if unary.Response.Trailer.Get("Grpc-Status") != "0" || unary.Response.Trailer.Get("X-Checksum-Bin") != "AAE=" {
+		t.Fatalf("unexpected response trailer %v", unary.Response.Trailer)
+	}
+
+	duplex := c.Interactions[4]
+	if duplex.Request.Body != "{\"payload\":{\"body\":\"cGluZw==\"}}\n{\"payload\":{\"body\":\"cG9uZw==\"}}" {
+		t.Fatalf("unexpected stream request body %s", duplex.Request.Body)
+	}
+
+	// Replay the calls without a server
+	rec, err = grpcrecorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithSkipRequestLatency(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	noServer := func(context.Context, string) (net.Conn, error) {
+		return nil, errors.New("no server")
+	}
+
+	replayed := exercise(t, newTestClient(t, rec, noServer))
+	if strings.Join(replayed, "\n") != strings.Join(recorded, "\n") {
+		t.Fatalf("expected replayed transcript:\n%s\ngot:\n%s", strings.Join(recorded, "\n"), strings.Join(replayed, "\n"))
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassPath := filepath.Join(dir, "grpc")
+
+	rec, err := grpcrecorder.New(cassPath, recorder.WithMode(recorder.ModeRecordOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, newTestClient(t, rec, newTestServer(t)))
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = grpcrecorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly), recorder.WithSkipRequestLatency(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client := newTestClient(t, rec, func(context.Context, string) (net.Conn, error) {
+		return nil, errors.New("no server")
+	})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user", "ava")
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("bye")}})
+	if !errors.Is(err, cassette.ErrInteractionNotFound) {
+		t.Fatalf("expected ErrInteractionNotFound, got %v", err)
+	}
+
+	duplex, err := client.FullDuplexCall(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = duplex.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte("pong")}})
+	if !errors.Is(err, grpcrecorder.ErrMessageMismatch) {
+		t.Fatalf("expected ErrMessageMismatch, got %v", err)
+	}
+
+	// Calls with different metadata match, when the metadata is ignored
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("hello")}}
+	if _, err := client.UnaryCall(context.Background(), req); !errors.Is(err, cassette.ErrInteractionNotFound) {
+		t.Fatalf("expected ErrInteractionNotFound, got %v", err)
+	}
+
+	rec, err = grpcrecorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithSkipRequestLatency(true),
+		recorder.WithMatcher(grpcrecorder.NewMatcher("x-user")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client = newTestClient(t, rec, func(context.Context, string) (net.Conn, error) {
+		return nil, errors.New("no server")
+	})
+
+	resp, err := client.UnaryCall(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(resp.Payload.Body) != "hello" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+}
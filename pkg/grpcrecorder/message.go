@@ -0,0 +1,210 @@
+package grpcrecorder
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// ErrUnsupportedMessage is returned, when a message is not a protobuf message,
+// and cannot be recorded.
+var ErrUnsupportedMessage = errors.New("unsupported message type")
+
+// Trailer names of the status of a call
+const (
+	statusTrailer  = "Grpc-Status"
+	messageTrailer = "Grpc-Message"
+	detailsTrailer = "Grpc-Status-Details-Bin"
+)
+
+// protoMessage returns the given message as a protobuf message.
+func protoMessage(m any) (proto.Message, error) {
+	switch msg := m.(type) {
+	case proto.Message:
+		return msg, nil
+	case protoadapt.MessageV1:
+		return protoadapt.MessageV2Of(msg), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedMessage, m)
+	}
+}
+
+// encodeMessage encodes the message for the cassette. Messages are encoded in
+// the protobuf JSON format, when the descriptors of all the types they contain
+// are available, e.g. the types of google.protobuf.Any fields, and in the
+// protobuf wire format encoded as base64 otherwise.
+func encodeMessage(m any) (string, error) {
+	msg, err := protoMessage(m)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := protojson.Marshal(msg)
+	if err == nil {
+		// The output of protojson is deliberately unstable, so it is
+		// compacted in order to be compared
+		var b bytes.Buffer
+		if err := json.Compact(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	data, err = proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decodeMessage decodes the message encoded by [encodeMessage] into m.
+func decodeMessage(data string, m any) error {
+	msg, err := protoMessage(m)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(data, "{") {
+		return protojson.Unmarshal([]byte(data), msg)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+
+	return proto.Unmarshal(raw, msg)
+}
+
+// messageEqual returns true, if the encoded message is equal to the given
+// message.
+func messageEqual(data string, m any) bool {
+	msg, err := protoMessage(m)
+	if err != nil {
+		return false
+	}
+
+	recorded := msg.ProtoReflect().New().Interface()
+	if err := decodeMessage(data, recorded); err != nil {
+		return false
+	}
+
+	return proto.Equal(recorded, msg)
+}
+
+// joinMessages joins the encoded messages into a body, one message per line.
+// The lines are unambiguous, since base64 does not use curly braces.
+func joinMessages(messages []string) string {
+	return strings.Join(messages, "\n")
+}
+
+// splitMessages splits a body into the encoded messages.
+func splitMessages(body string) []string {
+	if body == "" {
+		return nil
+	}
+
+	return strings.Split(body, "\n")
+}
+
+// headerFromMD converts the metadata to HTTP headers. Binary values are encoded
+// as base64, as they are on the wire.
+func headerFromMD(md metadata.MD) http.Header {
+	header := make(http.Header, len(md))
+	for key, values := range md {
+		for _, v := range values {
+			if strings.HasSuffix(key, "-bin") {
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+			header.Add(key, v)
+		}
+	}
+
+	return header
+}
+
+// mdFromHeader converts the HTTP headers to metadata, excluding the status of
+// the call.
+func mdFromHeader(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		switch http.CanonicalHeaderKey(key) {
+		case statusTrailer, messageTrailer, detailsTrailer:
+			continue
+		}
+
+		for _, v := range values {
+			if strings.HasSuffix(strings.ToLower(key), "-bin") {
+				if raw, err := base64.StdEncoding.DecodeString(v); err == nil {
+					v = string(raw)
+				}
+			}
+			md.Append(key, v)
+		}
+	}
+
+	return md
+}
+
+// trailerFromStatus converts the trailer metadata and the status of a call to
+// HTTP trailers.
+func trailerFromStatus(md metadata.MD, st *status.Status) (http.Header, error) {
+	trailer := headerFromMD(md)
+	trailer.Set(statusTrailer, strconv.Itoa(int(st.Code())))
+	if st.Message() != "" {
+		trailer.Set(messageTrailer, st.Message())
+	}
+
+	if len(st.Details()) > 0 {
+		data, err := proto.Marshal(st.Proto())
+		if err != nil {
+			return nil, err
+		}
+		trailer.Set(detailsTrailer, base64.StdEncoding.EncodeToString(data))
+	}
+
+	return trailer, nil
+}
+
+// statusFromTrailer returns the status of a call from the HTTP trailers. A
+// missing status is considered OK.
+func statusFromTrailer(trailer http.Header) (*status.Status, error) {
+	if details := trailer.Get(detailsTrailer); details != "" {
+		data, err := base64.StdEncoding.DecodeString(details)
+		if err != nil {
+			return nil, err
+		}
+
+		var st spb.Status
+		if err := proto.Unmarshal(data, &st); err != nil {
+			return nil, err
+		}
+
+		return status.FromProto(&st), nil
+	}
+
+	code := codes.OK
+	if v := trailer.Get(statusTrailer); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s trailer: %w", statusTrailer, err)
+		}
+		code = codes.Code(n)
+	}
+
+	return status.New(code, trailer.Get(messageTrailer)), nil
+}
@@ -0,0 +1,56 @@
+package grpcrecorder
+
+import (
+	"strings"
+	"testing"
+
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message proto.Message
+		want    string
+	}{
+		{
+			name:    "json",
+			message: &testpb.SimpleRequest{ResponseSize: 1, Payload: &testpb.Payload{Body: []byte("a")}},
+			want:    `{"responseSize":1,"payload":{"body":"YQ=="}}`,
+		},
+		{
+			// The descriptor of the embedded type is not available
+			name:    "base64",
+			message: &anypb.Any{TypeUrl: "type.googleapis.com/unknown.Message", Value: []byte{0x08, 0x01}},
+			want:    "CiN0eXBlLmdvb2dsZWFwaXMuY29tL3Vua25vd24uTWVzc2FnZRICCAE=",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := encodeMessage(test.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data != test.want {
+				t.Fatalf("expected %s, got %s", test.want, data)
+			}
+
+			decoded := test.message.ProtoReflect().New().Interface()
+			if err := decodeMessage(data, decoded); err != nil {
+				t.Fatal(err)
+			}
+
+			if !proto.Equal(decoded, test.message) || !messageEqual(data, test.message) {
+				t.Fatalf("expected %v, got %v", test.message, decoded)
+			}
+		})
+	}
+
+	if _, err := encodeMessage("not a message"); err == nil || !strings.Contains(err.Error(), ErrUnsupportedMessage.Error()) {
+		t.Fatalf("expected ErrUnsupportedMessage, got %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package grpcrecorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
+)
+
+// StreamClientInterceptor returns the interceptor, which records and replays
+// streams.
+func (r *Recorder) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if r.rec.Mode() == recorder.ModePassthrough {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		c := &call{
+			stream: true,
+		}
+
+		hr, err := newRequest(ctx, c, authority(cc.Target()), method)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := r.rec.RoundTrip(hr)
+		switch {
+		case errors.Is(err, errRecordStream):
+			cs, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				return nil, err
+			}
+
+			s := &recordingStream{
+				ClientStream: cs,
+				rec:          r.rec,
+				desc:         desc,
+				request:      hr,
+				call:         c,
+			}
+
+			return s, nil
+		case err != nil:
+			return nil, err
+		}
+
+		return newReplayStream(ctx, method, resp)
+	}
+}
+
+// recordingStream is a stream, which records the messages of the real stream,
+// and records the stream once it is finished.
+type recordingStream struct {
+	grpc.ClientStream
+
+	// rec is the recorder of the stream
+	rec *recorder.Recorder
+
+	// desc describes the stream
+	desc *grpc.StreamDesc
+
+	// request is the request, which represents the stream
+	request *http.Request
+
+	// call is the call of the request
+	call *call
+
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// received are the received messages
+	received []any
+
+	// finished is true, once the stream has been recorded
+	finished bool
+}
+
+// SendMsg implements the [grpc.ClientStream] interface.
+func (s *recordingStream) SendMsg(m any) error {
+	msg, err := protoMessage(m)
+	if err != nil {
+		return err
+	}
+
+	if err := s.ClientStream.SendMsg(m); err != nil {
+		return err
+	}
+
+	// Messages may be reused by the caller once sent
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.call.messages = append(s.call.messages, proto.Clone(msg))
+
+	return nil
+}
+
+// RecvMsg implements the [grpc.ClientStream] interface.
+func (s *recordingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		msg, perr := protoMessage(m)
+		if perr != nil {
+			return perr
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, proto.Clone(msg))
+		s.mu.Unlock()
+
+		// Streams without server streaming are finished once the
+		// single response was received
+		if !s.desc.ServerStreams {
+			return s.finish(io.EOF)
+		}
+
+		return nil
+	}
+
+	if ferr := s.finish(err); ferr != nil && !errors.Is(ferr, err) {
+		return ferr
+	}
+
+	return err
+}
+
+// finish records the finished stream with the given result. It returns the
+// result, or the error, which prevented recording the stream.
+func (s *recordingStream) finish(result error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+
+	header, _ := s.ClientStream.Header()
+	resp, err := newResponse(s.request, s.received, header, s.ClientStream.Trailer(), result)
+	if err != nil {
+		return err
+	}
+
+	// The request is created again with all sent messages as its body
+	c := &call{
+		messages: s.call.messages,
+		stream:   true,
+		response: resp,
+	}
+
+	// The stream may have finished, because its context was canceled
+	ctx := context.WithoutCancel(s.request.Context())
+	r, err := newRequest(ctx, c, s.request.Host, s.request.URL.Path)
+	if err != nil {
+		return err
+	}
+
+	recorded, err := s.rec.RoundTrip(r)
+	if err != nil {
+		return fmt.Errorf("failed to record stream %s: %w", s.request.URL.Path, err)
+	}
+	recorded.Body.Close()
+
+	if errors.Is(result, io.EOF) && !s.desc.ServerStreams {
+		return nil
+	}
+
+	return result
+}
+
+// replayStream is a stream, which replays a recorded stream.
+type replayStream struct {
+	// ctx is the context of the stream
+	ctx context.Context
+
+	// method is the full method of the stream
+	method string
+
+	// header is the header metadata of the stream
+	header metadata.MD
+
+	// trailer is the trailer metadata of the stream
+	trailer metadata.MD
+
+	// status is the status of the stream
+	status *status.Status
+
+	// requests are the recorded request messages
+	requests []string
+
+	// responses are the recorded response messages
+	responses []string
+
+	// mu protects the fields below
+	mu sync.Mutex
+
+	// sent is the number of sent messages
+	sent int
+
+	// received is the number of received messages
+	received int
+}
+
+// newReplayStream creates a stream, which replays the recorded response.
+func newReplayStream(ctx context.Context, method string, resp *http.Response) (*replayStream, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := io.ReadAll(resp.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := statusFromTrailer(resp.Trailer)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &replayStream{
+		ctx:       ctx,
+		method:    method,
+		header:    mdFromHeader(resp.Header),
+		trailer:   mdFromHeader(resp.Trailer),
+		status:    st,
+		requests:  splitMessages(string(requests)),
+		responses: splitMessages(string(body)),
+	}
+
+	return s, nil
+}
+
+// Header implements the [grpc.ClientStream] interface.
+func (s *replayStream) Header() (metadata.MD, error) {
+	return s.header, nil
+}
+
+// Trailer implements the [grpc.ClientStream] interface.
+func (s *replayStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+// CloseSend implements the [grpc.ClientStream] interface.
+func (s *replayStream) CloseSend() error {
+	return nil
+}
+
+// Context implements the [grpc.ClientStream] interface.
+func (s *replayStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg implements the [grpc.ClientStream] interface. It returns
+// [ErrMessageMismatch], when the message does not match the recorded one.
+func (s *replayStream) SendMsg(m any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent >= len(s.requests) || !messageEqual(s.requests[s.sent], m) {
+		return fmt.Errorf("%w: message %d of %s", ErrMessageMismatch, s.sent, s.method)
+	}
+	s.sent += 1
+
+	return nil
+}
+
+// RecvMsg implements the [grpc.ClientStream] interface.
+func (s *replayStream) RecvMsg(m any) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.received < len(s.responses) {
+		s.received += 1
+		return decodeMessage(s.responses[s.received-1], m)
+	}
+
+	if err := s.status.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
@@ -0,0 +1,549 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// GenerateOption is a function which configures the cassette generator.
+type GenerateOption func(g *generator)
+
+// generator generates interactions from the examples of a document.
+type generator struct {
+	// serverURL is the URL, which the paths of the generated requests
+	// are relative to
+	serverURL string
+}
+
+// WithServerURL is a [GenerateOption], which configures the generator to
+// create the requests relative to the given URL, e.g. the URL of a test server,
+// instead of the URL of the first server of the document.
+func WithServerURL(u string) GenerateOption {
+	opt := func(g *generator) {
+		g.serverURL = u
+	}
+
+	return opt
+}
+
+// defaultServerURL is the URL, which the paths of the generated requests are
+// relative to, when the document has no servers. Relative server URLs, e.g.
+// "/api/v1", are resolved against it.
+const defaultServerURL = "http://localhost"
+
+// methods are the methods of operations in the order of generation
+var methods = []string{
+	http.MethodGet,
+	http.MethodPut,
+	http.MethodPost,
+	http.MethodDelete,
+	http.MethodOptions,
+	http.MethodHead,
+	http.MethodPatch,
+	http.MethodTrace,
+}
+
+// Generate creates a new cassette with the given name from the examples of
+// the document, so that clients can be tested against the API in
+// [recorder.ModeReplayOnly] before any real recording is possible.
+//
+// An interaction is generated for each example of each response, whose status
+// code is declared explicitly or as a range, e.g. "2XX" generates a response
+// with status 200. Responses without content generate a single interaction
+// with an empty body. The request of an interaction uses the examples of the
+// request body and parameters with the same name as the response example, and
+// falls back to their first example otherwise, so that naming examples
+// consistently pairs requests with responses, e.g. an "unknown" example of the
+// id parameter with an "unknown" example of the 404 response. Since only the
+// first interaction of identical requests can be replayed, later ones are not
+// generated. The generated interactions may be replayed any number of times,
+// and should be matched using [ExampleMatcher].
+//
+// The requests are relative to the URL of the first server of the document, or
+// to "http://localhost", if the document has no servers. A relative server URL,
+// e.g. "/api/v1", is resolved against "http://localhost", so that the requests
+// always have a scheme and a host. Use [WithServerURL] to create the requests
+// relative to another URL.
+//
+// An error is returned, when an example is missing for a path parameter, a
+// required parameter or a required request body.
+//
+// [recorder.ModeReplayOnly]: https://pkg.go.dev/gopkg.in/dnaeon/go-vcr.v4/pkg/recorder#ModeReplayOnly
+func (d *Document) Generate(name string, opts ...GenerateOption) (*cassette.Cassette, error) {
+	g := &generator{
+		serverURL: defaultServerURL,
+	}
+
+	if len(d.Servers) > 0 {
+		g.serverURL = d.Servers[0].URL
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	serverURL, err := url.Parse(g.serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+
+	if serverURL.Scheme == "" || serverURL.Host == "" {
+		base, _ := url.Parse(defaultServerURL)
+		g.serverURL = base.ResolveReference(serverURL).String()
+	}
+
+	templates := make([]string, 0, len(d.Paths))
+	for template := range d.Paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	c := cassette.New(name)
+	seen := make(map[string]bool)
+	for _, template := range templates {
+		item := d.Paths[template]
+		for _, method := range methods {
+			op := item.Operation(method)
+			if op == nil {
+				continue
+			}
+
+			interactions, err := d.generate(g, template, method, item, op)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, template, err)
+			}
+
+			for _, i := range interactions {
+				key := i.Request.Method + " " + i.Request.URL + "\n" + i.Request.Body
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				c.AddInteraction(i)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// generate creates the interactions of a single operation.
+func (d *Document) generate(g *generator, template, method string, item *PathItem, op *Operation) ([]*cassette.Interaction, error) {
+	body, err := d.requestBody(op.RequestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqType string
+	var reqExamples map[string]any
+	if body != nil {
+		reqType, reqExamples, err = d.mediaExamples(body.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	params, err := d.parameters(item, op)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if statusCode(code) > 0 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	interactions := make([]*cassette.Interaction, 0)
+	for _, code := range codes {
+		resp, err := d.response(op.Responses[code])
+		if err != nil {
+			return nil, err
+		}
+
+		respType, respExamples, err := d.mediaExamples(resp.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(resp.Content) == 0 {
+			respExamples = map[string]any{"": nil}
+		}
+
+		for _, name := range sortedKeys(respExamples) {
+			req, err := d.exampleRequest(g, template, method, params, name, body, reqType, reqExamples)
+			if err != nil {
+				return nil, err
+			}
+
+			i := &cassette.Interaction{
+				Request: *req,
+				Response: cassette.Response{
+					Proto:      "HTTP/1.1",
+					ProtoMajor: 1,
+					ProtoMinor: 1,
+					Code:       statusCode(code),
+					Status:     fmt.Sprintf("%d %s", statusCode(code), http.StatusText(statusCode(code))),
+					Headers:    make(http.Header),
+				},
+				Repeat: cassette.RepeatUnlimited,
+			}
+
+			for header, h := range resp.Headers {
+				if h != nil && h.Example != nil {
+					i.Response.Headers.Set(header, fmt.Sprint(h.Example))
+				}
+			}
+
+			if len(resp.Content) > 0 {
+				respBody, err := encodeExample(respType, respExamples[name])
+				if err != nil {
+					return nil, err
+				}
+				if !strings.Contains(respType, "*") {
+					i.Response.Headers.Set("Content-Type", respType)
+				}
+				i.Response.Body = respBody
+			}
+			i.Response.ContentLength = int64(len(i.Response.Body))
+
+			interactions = append(interactions, i)
+		}
+	}
+
+	return interactions, nil
+}
+
+// exampleRequest creates the request of the example with the given name.
+func (d *Document) exampleRequest(g *generator, template, method string, params []*Parameter, name string, body *RequestBody, reqType string, reqExamples map[string]any) (*cassette.Request, error) {
+	path := template
+	query := make(url.Values)
+	headers := make(http.Header)
+	for _, p := range params {
+		value, ok, err := d.parameterExample(p, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			if p.Required || p.In == "path" {
+				return nil, fmt.Errorf("no example for %s parameter %q", p.In, p.Name)
+			}
+			continue
+		}
+
+		values := exampleValues(value)
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(strings.Join(values, ",")))
+		case "query":
+			query[p.Name] = values
+		case "header":
+			headers[http.CanonicalHeaderKey(p.Name)] = values
+		case "cookie":
+			headers.Add("Cookie", (&http.Cookie{Name: p.Name, Value: strings.Join(values, ",")}).String())
+		}
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(g.serverURL, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+
+	req := &cassette.Request{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Method:     method,
+		URL:        u.String(),
+		Headers:    headers,
+	}
+
+	if body == nil {
+		return req, nil
+	}
+
+	example, ok := reqExamples[name]
+	if !ok && len(reqExamples) > 0 {
+		example, ok = reqExamples[sortedKeys(reqExamples)[0]], true
+	}
+
+	if !ok {
+		if body.Required {
+			return nil, fmt.Errorf("no example for the request body")
+		}
+		return req, nil
+	}
+
+	req.Body, err = encodeExample(reqType, example)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(req.Body))
+	if !strings.Contains(reqType, "*") {
+		req.Headers.Set("Content-Type", reqType)
+	}
+
+	return req, nil
+}
+
+// parameters returns the resolved parameters of the operation, where
+// operation parameters override the path parameters with the same name and
+// location.
+func (d *Document) parameters(item *PathItem, op *Operation) ([]*Parameter, error) {
+	params := make([]*Parameter, 0)
+	index := make(map[string]int)
+	for _, list := range [][]*Parameter{item.Parameters, op.Parameters} {
+		for _, p := range list {
+			p, err := d.parameter(p)
+			if err != nil {
+				return nil, err
+			}
+
+			key := p.In + "." + p.Name
+			if n, ok := index[key]; ok {
+				params[n] = p
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, p)
+		}
+	}
+
+	return params, nil
+}
+
+// parameterExample returns the example value of the parameter with the given
+// name, or its first example, example, or the example or default value of its
+// schema.
+func (d *Document) parameterExample(p *Parameter, name string) (any, bool, error) {
+	if len(p.Examples) > 0 {
+		if _, ok := p.Examples[name]; !ok {
+			name = sortedKeys(p.Examples)[0]
+		}
+
+		e, err := d.example(p.Examples[name])
+		if err != nil {
+			return nil, false, err
+		}
+
+		return e.Value, true, nil
+	}
+
+	if p.Example != nil {
+		return p.Example, true, nil
+	}
+
+	s, err := d.schema(p.Schema)
+	if err != nil || s == nil {
+		return nil, false, err
+	}
+
+	switch {
+	case s.Example != nil:
+		return s.Example, true, nil
+	case s.Default != nil:
+		return s.Default, true, nil
+	default:
+		return nil, false, nil
+	}
+}
+
+// mediaExamples returns the media type of the content, preferring JSON, and
+// its examples by name. A single example of the media type or its schema is
+// returned with an empty name.
+func (d *Document) mediaExamples(content map[string]*MediaType) (string, map[string]any, error) {
+	if len(content) == 0 {
+		return "", nil, nil
+	}
+
+	mediaType := ""
+	for _, key := range sortedKeys(content) {
+		t, _, err := mime.ParseMediaType(key)
+		if err == nil && isJSON(t) {
+			mediaType = key
+			break
+		}
+	}
+
+	if mediaType == "" {
+		mediaType = sortedKeys(content)[0]
+	}
+
+	media := content[mediaType]
+	examples := make(map[string]any)
+	if media == nil {
+		return mediaType, examples, nil
+	}
+
+	for name, e := range media.Examples {
+		e, err := d.example(e)
+		if err != nil {
+			return "", nil, err
+		}
+		examples[name] = e.Value
+	}
+
+	if len(examples) > 0 {
+		return mediaType, examples, nil
+	}
+
+	if media.Example != nil {
+		examples[""] = media.Example
+		return mediaType, examples, nil
+	}
+
+	s, err := d.schema(media.Schema)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if s != nil && s.Example != nil {
+		examples[""] = s.Example
+	}
+
+	return mediaType, examples, nil
+}
+
+// encodeExample encodes the example value as a body of the given media type.
+func encodeExample(mediaType string, value any) (string, error) {
+	t, _, _ := mime.ParseMediaType(mediaType)
+	switch {
+	case value == nil:
+		return "", nil
+	case isJSON(t):
+		data, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	case t == "application/x-www-form-urlencoded":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Sprint(value), nil
+		}
+		form := make(url.Values)
+		for name, v := range obj {
+			form[name] = exampleValues(v)
+		}
+		return form.Encode(), nil
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// exampleValues returns the string values of a parameter example.
+func exampleValues(value any) []string {
+	items, ok := value.([]any)
+	if !ok {
+		return []string{fmt.Sprint(value)}
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		values = append(values, fmt.Sprint(item))
+	}
+
+	return values
+}
+
+// statusCode returns the status code of a declared response, where ranges,
+// e.g. "2XX", are represented by their first code. It returns zero for the
+// "default" response.
+func statusCode(code string) int {
+	if len(code) == 3 && strings.HasSuffix(strings.ToUpper(code), "XX") {
+		code = code[:1] + "00"
+	}
+
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 100 || n > 599 {
+		return 0
+	}
+
+	return n
+}
+
+// sortedKeys returns the sorted keys of the map.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ExampleMatcher is a [cassette.MatcherFunc] for cassettes created by
+// [Document.Generate]. Unlike the default matcher, which compares the requests
+// exactly, it compares only the method, the URL with query parameters in any
+// order, the headers of the cassette request, and the body, where JSON bodies
+// are compared semantically.
+func ExampleMatcher(r *http.Request, i cassette.Request) bool {
+	if r.Method != i.Method {
+		return false
+	}
+
+	u, err := url.Parse(i.URL)
+	if err != nil {
+		return false
+	}
+
+	if r.URL.Scheme != u.Scheme || r.URL.Host != u.Host || r.URL.Path != u.Path {
+		return false
+	}
+
+	if r.URL.Query().Encode() != u.Query().Encode() {
+		return false
+	}
+
+	for name, values := range i.Headers {
+		if http.CanonicalHeaderKey(name) == "Content-Type" {
+			want, _, _ := mime.ParseMediaType(i.Headers.Get(name))
+			got, _, _ := mime.ParseMediaType(r.Header.Get(name))
+			if want != got {
+				return false
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(r.Header.Values(name), values) {
+			return false
+		}
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if string(body) == i.Body {
+		return true
+	}
+
+	var x, y any
+	if json.Unmarshal(body, &x) != nil || json.Unmarshal([]byte(i.Body), &y) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,399 @@
+// Package openapi validates the interactions recorded in a cassette against
+// an OpenAPI 3 document, and generates cassettes from the examples of the
+// document.
+//
+// Each recorded request must match an operation of the document by its path
+// and method, must provide the required parameters with values conforming to
+// their schemas, and must have a body conforming to the schema of its content
+// type. Each recorded response must be declared for its status code and must
+// have a body conforming to the declared schema.
+//
+// Generated cassettes contain an interaction for each example of each declared
+// response, which allows testing clients against the API before any real
+// recording is possible.
+//
+// The package supports the subset of OpenAPI 3.0 and 3.1, which is needed to
+// validate recorded interactions. Schemas support the type, nullable, enum,
+// properties, required, additionalProperties, items, allOf, anyOf and oneOf
+// keywords, as well as the minimum, maximum, minLength, maxLength, pattern,
+// minItems and maxItems constraints, while formats are not validated.
+// References are supported within the document and must point to one of the
+// components of the document, e.g. "#/components/schemas/User".
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnsupportedRef is returned, when a reference does not point to one of the
+// components of the document.
+var ErrUnsupportedRef = errors.New("unsupported reference")
+
+// Document is an OpenAPI 3 document.
+type Document struct {
+	// OpenAPI is the version of the OpenAPI specification
+	OpenAPI string `yaml:"openapi"`
+
+	// Servers are the servers, which provide the API
+	Servers []*Server `yaml:"servers"`
+
+	// Paths are the operations of the API by path template
+	Paths map[string]*PathItem `yaml:"paths"`
+
+	// Components are the reusable objects of the document
+	Components Components `yaml:"components"`
+}
+
+// Server is a server, which provides the API.
+type Server struct {
+	// URL is the URL of the server, whose path is the base path of the
+	// path templates
+	URL string `yaml:"url"`
+}
+
+// Components are the reusable objects of the document, which are referenced
+// by other objects.
+type Components struct {
+	Schemas       map[string]*Schema      `yaml:"schemas"`
+	Parameters    map[string]*Parameter   `yaml:"parameters"`
+	RequestBodies map[string]*RequestBody `yaml:"requestBodies"`
+	Responses     map[string]*Response    `yaml:"responses"`
+	Examples      map[string]*Example     `yaml:"examples"`
+}
+
+// PathItem describes the operations available on a single path.
+type PathItem struct {
+	// Parameters are the parameters shared by all operations of the path
+	Parameters []*Parameter `yaml:"parameters"`
+
+	Get     *Operation `yaml:"get"`
+	Put     *Operation `yaml:"put"`
+	Post    *Operation `yaml:"post"`
+	Delete  *Operation `yaml:"delete"`
+	Options *Operation `yaml:"options"`
+	Head    *Operation `yaml:"head"`
+	Patch   *Operation `yaml:"patch"`
+	Trace   *Operation `yaml:"trace"`
+}
+
+// Operation returns the operation of the path for the given method, or nil if
+// the path does not support the method.
+func (p *PathItem) Operation(method string) *Operation {
+	switch strings.ToUpper(method) {
+	case http.MethodGet:
+		return p.Get
+	case http.MethodPut:
+		return p.Put
+	case http.MethodPost:
+		return p.Post
+	case http.MethodDelete:
+		return p.Delete
+	case http.MethodOptions:
+		return p.Options
+	case http.MethodHead:
+		return p.Head
+	case http.MethodPatch:
+		return p.Patch
+	case http.MethodTrace:
+		return p.Trace
+	default:
+		return nil
+	}
+}
+
+// Operation describes a single API operation on a path.
+type Operation struct {
+	// OperationID is the unique identifier of the operation
+	OperationID string `yaml:"operationId"`
+
+	// Parameters are the parameters of the operation, which override the
+	// parameters of the path with the same name and location
+	Parameters []*Parameter `yaml:"parameters"`
+
+	// RequestBody is the request body of the operation
+	RequestBody *RequestBody `yaml:"requestBody"`
+
+	// Responses are the responses of the operation by status code, status
+	// code range, e.g. "2XX", or "default"
+	Responses map[string]*Response `yaml:"responses"`
+}
+
+// Parameter describes a single parameter of an operation.
+type Parameter struct {
+	// Ref is the reference to a parameter of the components
+	Ref string `yaml:"$ref"`
+
+	// Name is the name of the parameter
+	Name string `yaml:"name"`
+
+	// In is the location of the parameter, i.e. "path", "query", "header"
+	// or "cookie"
+	In string `yaml:"in"`
+
+	// Required specifies whether the parameter must be present
+	Required bool `yaml:"required"`
+
+	// Schema is the schema of the parameter value
+	Schema *Schema `yaml:"schema"`
+
+	// Example is an example value of the parameter
+	Example any `yaml:"example"`
+
+	// Examples are named example values of the parameter
+	Examples map[string]*Example `yaml:"examples"`
+}
+
+// RequestBody describes the request body of an operation.
+type RequestBody struct {
+	// Ref is the reference to a request body of the components
+	Ref string `yaml:"$ref"`
+
+	// Required specifies whether the request body must be present
+	Required bool `yaml:"required"`
+
+	// Content is the content of the body by media type
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// Response describes a single response of an operation.
+type Response struct {
+	// Ref is the reference to a response of the components
+	Ref string `yaml:"$ref"`
+
+	// Description is the description of the response
+	Description string `yaml:"description"`
+
+	// Headers are the headers of the response by name
+	Headers map[string]*Header `yaml:"headers"`
+
+	// Content is the content of the body by media type
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+// Header describes a single response header.
+type Header struct {
+	// Schema is the schema of the header value
+	Schema *Schema `yaml:"schema"`
+
+	// Example is an example value of the header
+	Example any `yaml:"example"`
+}
+
+// MediaType describes the content of a body for a single media type.
+type MediaType struct {
+	// Schema is the schema of the body
+	Schema *Schema `yaml:"schema"`
+
+	// Example is an example of the body
+	Example any `yaml:"example"`
+
+	// Examples are named examples of the body
+	Examples map[string]*Example `yaml:"examples"`
+}
+
+// Example is a named example value.
+type Example struct {
+	// Ref is the reference to an example of the components
+	Ref string `yaml:"$ref"`
+
+	// Summary is a short description of the example
+	Summary string `yaml:"summary"`
+
+	// Value is the example value
+	Value any `yaml:"value"`
+}
+
+// Schema describes a value as a subset of JSON Schema.
+type Schema struct {
+	// Ref is the reference to a schema of the components
+	Ref string `yaml:"$ref"`
+
+	// Type are the allowed types of the value. In OpenAPI 3.1 the type may
+	// be a list of types.
+	Type SchemaType `yaml:"type"`
+
+	// Nullable specifies whether the value may be null, as used by
+	// OpenAPI 3.0
+	Nullable bool `yaml:"nullable"`
+
+	// Format is the format of the value, which is not validated
+	Format string `yaml:"format"`
+
+	// Enum are the allowed values
+	Enum []any `yaml:"enum"`
+
+	// Default is the default value
+	Default any `yaml:"default"`
+
+	// Example is an example value
+	Example any `yaml:"example"`
+
+	// Properties are the schemas of object properties
+	Properties map[string]*Schema `yaml:"properties"`
+
+	// Required are the properties, which must be present
+	Required []string `yaml:"required"`
+
+	// AdditionalProperties specifies whether properties, which are not
+	// declared, are allowed
+	AdditionalProperties *AdditionalProperties `yaml:"additionalProperties"`
+
+	// Items is the schema of array items
+	Items *Schema `yaml:"items"`
+
+	AllOf []*Schema `yaml:"allOf"`
+	AnyOf []*Schema `yaml:"anyOf"`
+	OneOf []*Schema `yaml:"oneOf"`
+
+	Minimum   *float64 `yaml:"minimum"`
+	Maximum   *float64 `yaml:"maximum"`
+	MinLength *int     `yaml:"minLength"`
+	MaxLength *int     `yaml:"maxLength"`
+	Pattern   string   `yaml:"pattern"`
+	MinItems  *int     `yaml:"minItems"`
+	MaxItems  *int     `yaml:"maxItems"`
+}
+
+// SchemaType are the allowed types of a schema.
+type SchemaType []string
+
+// UnmarshalYAML implements the [yaml.Unmarshaler] interface. It accepts both a
+// single type and a list of types.
+func (t *SchemaType) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = SchemaType{node.Value}
+		return nil
+	}
+
+	var types []string
+	if err := node.Decode(&types); err != nil {
+		return err
+	}
+	*t = types
+
+	return nil
+}
+
+// Has returns true, if the given type is allowed.
+func (t SchemaType) Has(name string) bool {
+	for _, v := range t {
+		if v == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AdditionalProperties specifies whether properties, which are not declared,
+// are allowed, and optionally their schema.
+type AdditionalProperties struct {
+	// Allowed specifies whether additional properties are allowed
+	Allowed bool
+
+	// Schema is the schema of additional properties
+	Schema *Schema
+}
+
+// UnmarshalYAML implements the [yaml.Unmarshaler] interface. It accepts both a
+// boolean and a schema.
+func (a *AdditionalProperties) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!bool" {
+		return node.Decode(&a.Allowed)
+	}
+
+	a.Allowed = true
+	a.Schema = &Schema{}
+
+	return node.Decode(a.Schema)
+}
+
+// Load reads the OpenAPI document, in YAML or JSON format, from the given
+// file.
+func Load(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// Parse parses the OpenAPI document in YAML or JSON format.
+func Parse(data []byte) (*Document, error) {
+	var d Document
+	if err := yaml.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(d.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", d.OpenAPI)
+	}
+
+	return &d, nil
+}
+
+// componentName returns the name of the component of the given kind, which
+// the reference points to.
+func componentName(ref, kind string) (string, error) {
+	prefix := "#/components/" + kind + "/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedRef, ref)
+	}
+
+	return strings.TrimPrefix(ref, prefix), nil
+}
+
+// resolve follows the references of a component of the given kind.
+func resolve[T any](components map[string]*T, kind string, v *T, ref func(*T) string) (*T, error) {
+	for seen := 0; v != nil && ref(v) != ""; seen++ {
+		if seen > len(components) {
+			return nil, fmt.Errorf("circular reference: %s", ref(v))
+		}
+
+		name, err := componentName(ref(v), kind)
+		if err != nil {
+			return nil, err
+		}
+
+		next, ok := components[name]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", ref(v))
+		}
+		v = next
+	}
+
+	return v, nil
+}
+
+// schema returns the schema, which the given schema refers to.
+func (d *Document) schema(s *Schema) (*Schema, error) {
+	return resolve(d.Components.Schemas, "schemas", s, func(s *Schema) string { return s.Ref })
+}
+
+// parameter returns the parameter, which the given parameter refers to.
+func (d *Document) parameter(p *Parameter) (*Parameter, error) {
+	return resolve(d.Components.Parameters, "parameters", p, func(p *Parameter) string { return p.Ref })
+}
+
+// requestBody returns the request body, which the given request body refers
+// to.
+func (d *Document) requestBody(b *RequestBody) (*RequestBody, error) {
+	return resolve(d.Components.RequestBodies, "requestBodies", b, func(b *RequestBody) string { return b.Ref })
+}
+
+// example returns the example, which the given example refers to.
+func (d *Document) example(e *Example) (*Example, error) {
+	return resolve(d.Components.Examples, "examples", e, func(e *Example) string { return e.Ref })
+}
+
+// response returns the response, which the given response refers to.
+func (d *Document) response(r *Response) (*Response, error) {
+	return resolve(d.Components.Responses, "responses", r, func(r *Response) string { return r.Ref })
+}
@@ -0,0 +1,420 @@
+package openapi_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/openapi"
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
+)
+
+func newInteraction(method, path, body string, code int, respBody string) *cassette.Interaction {
+	i := &cassette.Interaction{
+		Request: cassette.Request{
+			Method:  method,
+			URL:     "https://api.example.com/v1" + path,
+			Body:    body,
+			Headers: http.Header{},
+		},
+		Response: cassette.Response{
+			Code:    code,
+			Body:    respBody,
+			Headers: http.Header{},
+		},
+	}
+
+	if body != "" {
+		i.Request.Headers.Set("Content-Type", "application/json")
+	}
+
+	if respBody != "" {
+		i.Response.Headers.Set("Content-Type", "application/json")
+	}
+
+	return i
+}
+
+func TestValidateInteraction(t *testing.T) {
+	doc, err := openapi.Load("fixtures/users.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorResponse := newInteraction(http.MethodPost, "/users", `{"name": ""}`, http.StatusBadRequest, `{"message": "invalid", "code": 1}`)
+	errorResponse.Response.Headers.Set("Content-Type", "application/problem+json")
+
+	currentUser := newInteraction(http.MethodGet, "/users/me", "", http.StatusOK, `{"id": 1, "name": "Ava"}`)
+	currentUser.Request.Headers.Set("X-Request-Id", "abc")
+
+	tests := []struct {
+		name        string
+		interaction *cassette.Interaction
+		operation   string
+		violations  []string
+	}{
+		{
+			name:        "valid",
+			interaction: newInteraction(http.MethodGet, "/users?limit=10", "", http.StatusOK, `[{"id": 1, "name": "Ava", "manager": null}]`),
+			operation:   "GET /users",
+		},
+		{
+			name:        "literal path takes precedence",
+			interaction: currentUser,
+			operation:   "GET /users/me",
+		},
+		{
+			name:        "unknown path",
+			interaction: newInteraction(http.MethodGet, "/groups", "", http.StatusOK, ""),
+			violations:  []string{`request.url: no path matches "/v1/groups"`},
+		},
+		{
+			name:        "undeclared method",
+			interaction: newInteraction(http.MethodPut, "/users/1", "", http.StatusOK, ""),
+			violations:  []string{"request.method: PUT is not declared for /users/{id}"},
+		},
+		{
+			name:        "invalid parameters",
+			interaction: newInteraction(http.MethodGet, "/users/0", "", http.StatusOK, `{"id": 1, "name": "Ava"}`),
+			operation:   "GET /users/{id}",
+			violations:  []string{"request.path.id: 0 is less than 1"},
+		},
+		{
+			name:        "invalid query parameter",
+			interaction: newInteraction(http.MethodGet, "/users?limit=many", "", http.StatusOK, `[]`),
+			operation:   "GET /users",
+			violations:  []string{"request.query.limit: expected integer, got string"},
+		},
+		{
+			name:        "missing header",
+			interaction: newInteraction(http.MethodGet, "/users/me", "", http.StatusOK, `{"id": 1, "name": "Ava"}`),
+			operation:   "GET /users/me",
+			violations:  []string{"request.header.X-Request-Id: missing required parameter"},
+		},
+		{
+			name:        "invalid request body",
+			interaction: newInteraction(http.MethodPost, "/users", `{"role": "owner"}`, http.StatusCreated, `{"id": 1, "name": "Ava"}`),
+			operation:   "POST /users",
+			violations: []string{
+				`request.body: missing required property "name"`,
+				"request.body.role: value owner is not one of [admin member]",
+			},
+		},
+		{
+			name:        "missing request body",
+			interaction: newInteraction(http.MethodPost, "/users", "", http.StatusCreated, `{"id": 1, "name": "Ava"}`),
+			operation:   "POST /users",
+			violations:  []string{"request.body: missing required body"},
+		},
+		{
+			name:        "invalid response body",
+			interaction: newInteraction(http.MethodGet, "/users/1", "", http.StatusOK, `{"id": "1", "name": "Ava", "manager": "Jess"}`),
+			operation:   "GET /users/{id}",
+			violations: []string{
+				"response.body.id: expected integer, got string",
+				"response.body.manager: expected integer, got string",
+			},
+		},
+		{
+			name:        "status range and referenced response",
+			interaction: errorResponse,
+			operation:   "POST /users",
+			violations: []string{
+				"request.body.name: length 0 is less than 1",
+				"response.body.code: property is not allowed",
+			},
+		},
+		{
+			name:        "undeclared status",
+			interaction: newInteraction(http.MethodDelete, "/users/1", "", http.StatusNotFound, ""),
+			operation:   "DELETE /users/{id}",
+			violations:  []string{"response.code: status 404 is not declared"},
+		},
+		{
+			name:        "undeclared body",
+			interaction: newInteraction(http.MethodDelete, "/users/1", "", http.StatusNoContent, `{}`),
+			operation:   "DELETE /users/{id}",
+			violations:  []string{"response.body: body is not declared for status 204"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := doc.ValidateInteraction(test.interaction)
+			if result.Operation != test.operation {
+				t.Fatalf("expected operation %q, got %q", test.operation, result.Operation)
+			}
+
+			got := make([]string, 0)
+			for _, v := range result.Violations {
+				got = append(got, v.String())
+			}
+
+			if strings.Join(got, "\n") != strings.Join(test.violations, "\n") {
+				t.Fatalf("expected violations:\n%s\ngot:\n%s", strings.Join(test.violations, "\n"), strings.Join(got, "\n"))
+			}
+
+			if result.OK() != (len(test.violations) == 0) {
+				t.Fatalf("unexpected result %v", result.OK())
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	doc, err := openapi.Parse([]byte(`{
+		"openapi": "3.1.0",
+		"paths": {
+			"/items/{id}.json": {
+				"get": {
+					"responses": {
+						"200": {
+							"description": "An item",
+							"content": {
+								"application/json": {
+									"schema": {
+										"type": "object",
+										"properties": {
+											"price": {"type": ["number", "null"]},
+											"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 1}
+										},
+										"additionalProperties": {"type": "string"}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cassette.New("fixtures/items")
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "http://localhost/items/1.json"},
+		Response: cassette.Response{
+			Code:    http.StatusOK,
+			Headers: http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+			Body:    `{"price": null, "tags": ["a"], "color": "red"}`,
+		},
+	})
+	c.AddInteraction(&cassette.Interaction{
+		Request: cassette.Request{Method: http.MethodGet, URL: "http://localhost/items/2.json"},
+		Response: cassette.Response{
+			Code:    http.StatusOK,
+			Headers: http.Header{"Content-Type": {"application/json"}},
+			Body:    `{"price": 1.5, "tags": ["a", 1], "size": 2}`,
+		},
+	})
+
+	report := doc.Validate(c)
+	if report.Valid() {
+		t.Fatal("expected report to be invalid")
+	}
+
+	var b bytes.Buffer
+	if _, err := report.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[0] GET http://localhost/items/1.json: ok
+[1] GET http://localhost/items/2.json: invalid
+    response.body.size: expected string, got integer
+    response.body.tags: 2 items are more than 1
+    response.body.tags[1]: expected string, got integer
+fixtures/items: 2 interactions, 1 invalid
+`
+	if b.String() != want {
+		t.Fatalf("expected report:\n%s\ngot:\n%s", want, b.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := openapi.Parse([]byte(`swagger: "2.0"`)); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+
+	doc, err := openapi.Parse([]byte(`
+openapi: 3.0.0
+servers:
+  - url: /v1
+paths:
+  /a:
+    get:
+      responses:
+        200:
+          $ref: '#/definitions/A'
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := doc.ValidateInteraction(newInteraction(http.MethodGet, "/a", "", http.StatusOK, ""))
+	if len(result.Violations) != 1 || !strings.Contains(result.Violations[0].Message, openapi.ErrUnsupportedRef.Error()) {
+		t.Fatalf("expected unsupported reference, got %v", result.Violations)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	doc, err := openapi.Load("fixtures/users.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "go-vcr-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := doc.Generate(filepath.Join(dir, "users"), openapi.WithServerURL("http://localhost:8080/v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]string, 0)
+	for _, i := range c.Interactions {
+		got = append(got, strings.TrimSpace(i.Request.Method+" "+i.Request.URL+" "+i.Request.Body+" -> "+i.Response.Status+" "+i.Response.Body))
+	}
+
+	want := []string{
+		`GET http://localhost:8080/v1/users  -> 200 OK [{"id":1,"name":"Ava"}]`,
+		`POST http://localhost:8080/v1/users {"name":"Ava"} -> 201 Created {"id":1,"name":"Ava"}`,
+		`POST http://localhost:8080/v1/users {"name":""} -> 400 Bad Request {"message":"name is empty"}`,
+		`GET http://localhost:8080/v1/users/me  -> 200 OK {"id":1,"name":"Ava"}`,
+		`GET http://localhost:8080/v1/users/1  -> 200 OK {"id":1,"name":"Ava"}`,
+		`GET http://localhost:8080/v1/users/999  -> 404 Not Found {"message":"user not found"}`,
+		`DELETE http://localhost:8080/v1/users/1  -> 204 No Content`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected interactions:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	// The responses of the generated interactions conform to the document
+	for _, result := range doc.Validate(c).Results {
+		if result.Interaction.Response.Code < http.StatusBadRequest && !result.OK() {
+			t.Fatalf("unexpected violations of interaction %d: %v", result.Interaction.ID, result.Violations)
+		}
+	}
+
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := recorder.New(
+		c.Name,
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithMatcher(openapi.ExampleMatcher),
+		recorder.WithSkipRequestLatency(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client := rec.GetDefaultClient()
+	for n := 0; n < 2; n++ {
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8080/v1/users", strings.NewReader(`{ "name": "Ava" }`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusCreated || string(body) != `{"id":1,"name":"Ava"}` {
+			t.Fatalf("unexpected response %d: %s", resp.StatusCode, body)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/v1/users/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("expected request without the X-Request-Id header not to match")
+	}
+}
+
+func TestGenerateRelativeServerURL(t *testing.T) {
+	doc, err := openapi.Parse([]byte(`
+openapi: 3.0.0
+servers:
+  - url: /api/v1
+paths:
+  /users:
+    get:
+      responses:
+        200:
+          description: The users
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := doc.Generate("fixtures/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 interaction, got %d", len(c.Interactions))
+	}
+
+	// Relative server URLs are resolved against the default host
+	if got := c.Interactions[0].Request.URL; got != "http://localhost/api/v1/users" {
+		t.Fatalf("want URL %q, got %q", "http://localhost/api/v1/users", got)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost/api/v1/users", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !openapi.ExampleMatcher(req, c.Interactions[0].Request) {
+		t.Fatal("expected the request to match the generated interaction")
+	}
+}
+
+func TestGenerateMissingExample(t *testing.T) {
+	doc, err := openapi.Parse([]byte(`
+openapi: 3.0.0
+paths:
+  /users/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        200:
+          description: The user
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = doc.Generate("fixtures/users")
+	if err == nil || err.Error() != `GET /users/{id}: no example for path parameter "id"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package openapi
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// validateValue validates the given decoded JSON value against the schema and
+// returns the violations. The path is the location of the value, e.g.
+// "response.body.items[0].id".
+func (d *Document) validateValue(s *Schema, v any, path string) []Violation {
+	s, err := d.schema(s)
+	if err != nil {
+		return []Violation{violation(path, "%s", err)}
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	if v == nil {
+		if s.Nullable || s.Type.Has("null") || (len(s.Type) == 0 && len(s.Enum) == 0 && !s.composed()) {
+			return nil
+		}
+	}
+
+	problems := make([]Violation, 0)
+	if len(s.Type) > 0 {
+		if t := jsonType(v); !s.Type.Has(t) && (t != "integer" || !s.Type.Has("number")) {
+			return append(problems, violation(path, "expected %s, got %s", typeList(s.Type), t))
+		}
+	}
+
+	if len(s.Enum) > 0 && !enumContains(s.Enum, v) {
+		problems = append(problems, violation(path, "value %v is not one of %v", v, s.Enum))
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			problems = append(problems, violation(path, "length %d is less than %d", n, *s.MinLength))
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			problems = append(problems, violation(path, "length %d is greater than %d", n, *s.MaxLength))
+		}
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			switch {
+			case err != nil:
+				problems = append(problems, violation(path, "invalid pattern: %s", err))
+			case !re.MatchString(val):
+				problems = append(problems, violation(path, "%q does not match pattern %q", val, s.Pattern))
+			}
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			problems = append(problems, violation(path, "%v is less than %v", val, *s.Minimum))
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			problems = append(problems, violation(path, "%v is greater than %v", val, *s.Maximum))
+		}
+	case []any:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			problems = append(problems, violation(path, "%d items are less than %d", len(val), *s.MinItems))
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			problems = append(problems, violation(path, "%d items are more than %d", len(val), *s.MaxItems))
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				problems = append(problems, d.validateValue(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]any:
+		problems = append(problems, d.validateObject(s, val, path)...)
+	}
+
+	for _, sub := range s.AllOf {
+		problems = append(problems, d.validateValue(sub, v, path)...)
+	}
+
+	if len(s.AnyOf) > 0 && d.countValid(s.AnyOf, v, path) == 0 {
+		problems = append(problems, violation(path, "value does not match any of the schemas"))
+	}
+
+	if len(s.OneOf) > 0 {
+		if n := d.countValid(s.OneOf, v, path); n != 1 {
+			problems = append(problems, violation(path, "value matches %d schemas, expected exactly one", n))
+		}
+	}
+
+	return problems
+}
+
+// validateObject validates the properties of an object.
+func (d *Document) validateObject(s *Schema, obj map[string]any, path string) []Violation {
+	problems := make([]Violation, 0)
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			problems = append(problems, violation(path, "missing required property %q", name))
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		propPath := path + "." + name
+		if prop, ok := s.Properties[name]; ok {
+			problems = append(problems, d.validateValue(prop, obj[name], propPath)...)
+			continue
+		}
+
+		switch ap := s.AdditionalProperties; {
+		case ap == nil:
+		case !ap.Allowed:
+			problems = append(problems, violation(propPath, "property is not allowed"))
+		case ap.Schema != nil:
+			problems = append(problems, d.validateValue(ap.Schema, obj[name], propPath)...)
+		}
+	}
+
+	return problems
+}
+
+// countValid returns the number of schemas, which the value conforms to.
+func (d *Document) countValid(schemas []*Schema, v any, path string) int {
+	n := 0
+	for _, s := range schemas {
+		if len(d.validateValue(s, v, path)) == 0 {
+			n += 1
+		}
+	}
+
+	return n
+}
+
+// composed returns true, if the schema is composed of other schemas.
+func (s *Schema) composed() bool {
+	return len(s.AllOf) > 0 || len(s.AnyOf) > 0 || len(s.OneOf) > 0
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// typeList formats the allowed types for use in messages.
+func typeList(t SchemaType) string {
+	if len(t) == 1 {
+		return t[0]
+	}
+
+	return fmt.Sprintf("one of %v", []string(t))
+}
+
+// enumContains returns true, if the value is one of the enum values. Numbers
+// are compared by value, since the enum values are decoded from YAML.
+func enumContains(enum []any, v any) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(normalizeNumber(e), normalizeNumber(v)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeNumber converts numbers to float64.
+func normalizeNumber(v any) any {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	default:
+		return v
+	}
+}
+
+// parseValue converts the string value of a parameter or form field to the
+// type of the given schema, so that it can be validated as a JSON value.
+// Values, which cannot be converted, are returned as strings.
+func (d *Document) parseValue(s *Schema, raw []string) any {
+	s, err := d.schema(s)
+	if err != nil || s == nil {
+		return first(raw)
+	}
+
+	if s.Type.Has("array") {
+		items := make([]any, 0, len(raw))
+		for _, r := range raw {
+			items = append(items, d.parseValue(s.Items, []string{r}))
+		}
+		return items
+	}
+
+	value := first(raw)
+	switch {
+	case s.Type.Has("integer"), s.Type.Has("number"):
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case s.Type.Has("boolean"):
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+
+	return value
+}
+
+// first returns the first of the given values, or an empty string.
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
@@ -0,0 +1,446 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// Violation describes a part of an interaction, which does not conform to the
+// document.
+type Violation struct {
+	// Location is the location of the violation, e.g. "request.query.limit"
+	// or "response.body.items[0].id"
+	Location string
+
+	// Message describes the violation
+	Message string
+}
+
+// String implements the [fmt.Stringer] interface.
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Location, v.Message)
+}
+
+// violation creates a new violation with a formatted message.
+func violation(location, format string, args ...any) Violation {
+	return Violation{Location: location, Message: fmt.Sprintf(format, args...)}
+}
+
+// Result is the result of validating a single interaction.
+type Result struct {
+	// Interaction is the validated interaction
+	Interaction *cassette.Interaction
+
+	// Operation is the matched operation, e.g. "GET /users/{id}", or empty
+	// if no operation matches the request
+	Operation string
+
+	// Violations are the parts of the interaction, which do not conform to
+	// the document
+	Violations []Violation
+}
+
+// OK returns true, if the interaction conforms to the document.
+func (r *Result) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// Report is the validation report of a cassette.
+type Report struct {
+	// Cassette is the name of the validated cassette
+	Cassette string
+
+	// Results are the results of validating the interactions of the
+	// cassette, in the order of the interactions
+	Results []*Result
+}
+
+// Valid returns true, if all interactions conform to the document.
+func (r *Report) Valid() bool {
+	for _, result := range r.Results {
+		if !result.OK() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// WriteTo writes the report in a human-readable form to the given writer.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	var invalid int
+	for _, result := range r.Results {
+		i := result.Interaction
+		status := "ok"
+		if !result.OK() {
+			status = "invalid"
+			invalid += 1
+		}
+
+		fmt.Fprintf(&b, "[%d] %s %s: %s\n", i.ID, i.Request.Method, i.Request.URL, status)
+		for _, v := range result.Violations {
+			fmt.Fprintf(&b, "    %s\n", v)
+		}
+	}
+
+	fmt.Fprintf(&b, "%s: %d interactions, %d invalid\n", r.Cassette, len(r.Results), invalid)
+
+	n, err := io.WriteString(w, b.String())
+
+	return int64(n), err
+}
+
+// Validate validates the interactions of the cassette against the document
+// and returns the validation report.
+func (d *Document) Validate(c *cassette.Cassette) *Report {
+	report := &Report{
+		Cassette: c.Name,
+		Results:  make([]*Result, 0, len(c.Interactions)),
+	}
+
+	for _, i := range c.Interactions {
+		report.Results = append(report.Results, d.ValidateInteraction(i))
+	}
+
+	return report
+}
+
+// ValidateInteraction validates a single interaction against the document.
+// The bodies of templated responses are not validated, since they are
+// rendered only when replaying the interaction.
+func (d *Document) ValidateInteraction(i *cassette.Interaction) *Result {
+	result := &Result{
+		Interaction: i,
+		Violations:  make([]Violation, 0),
+	}
+
+	u, err := url.Parse(i.Request.URL)
+	if err != nil {
+		result.Violations = append(result.Violations, violation("request.url", "%s", err))
+		return result
+	}
+
+	template, item, pathParams := d.findPath(u.Path)
+	if item == nil {
+		result.Violations = append(result.Violations, violation("request.url", "no path matches %q", u.Path))
+		return result
+	}
+
+	op := item.Operation(i.Request.Method)
+	if op == nil {
+		result.Violations = append(result.Violations, violation("request.method", "%s is not declared for %s", i.Request.Method, template))
+		return result
+	}
+	result.Operation = fmt.Sprintf("%s %s", strings.ToUpper(i.Request.Method), template)
+
+	result.Violations = append(result.Violations, d.validateParameters(item, op, &i.Request, u, pathParams)...)
+	result.Violations = append(result.Violations, d.validateRequestBody(op, &i.Request)...)
+
+	if len(i.Sequence) == 0 {
+		result.Violations = append(result.Violations, d.validateResponse(op, &i.Response, "response", i.Template)...)
+	}
+
+	for n := range i.Sequence {
+		location := fmt.Sprintf("sequence[%d]", n)
+		result.Violations = append(result.Violations, d.validateResponse(op, &i.Sequence[n], location, i.Template)...)
+	}
+
+	return result
+}
+
+// findPath returns the path template and the path item, which match the given
+// request path, along with the values of the path parameters. Literal matches
+// take precedence over templated ones, e.g. "/users/me" is preferred over
+// "/users/{id}". The base paths of the servers are stripped from the request
+// path before matching.
+func (d *Document) findPath(path string) (string, *PathItem, map[string]string) {
+	prefixes := []string{""}
+	for _, s := range d.Servers {
+		u, err := url.Parse(s.URL)
+		if err != nil || strings.Contains(u.Path, "{") {
+			continue
+		}
+		if p := strings.TrimSuffix(u.Path, "/"); p != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+
+	templates := make([]string, 0, len(d.Paths))
+	for template := range d.Paths {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	var best string
+	var params map[string]string
+	bestScore := -1
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(path, prefix)
+
+		for _, template := range templates {
+			values, score, ok := matchTemplate(template, rest)
+			if ok && score > bestScore {
+				best, params, bestScore = template, values, score
+			}
+		}
+	}
+
+	if bestScore < 0 {
+		return "", nil, nil
+	}
+
+	return best, d.Paths[best], params
+}
+
+// templateParam matches the parameters of path templates
+var templateParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// matchTemplate matches the path against the path template and returns the
+// values of the path parameters and the number of literal characters of the
+// template as the score of the match.
+func matchTemplate(template, path string) (map[string]string, int, bool) {
+	var b strings.Builder
+	names := make([]string, 0)
+	literal := 0
+	last := 0
+	for _, m := range templateParam.FindAllStringSubmatchIndex(template, -1) {
+		b.WriteString(regexp.QuoteMeta(template[last:m[0]]))
+		b.WriteString("([^/]+)")
+		literal += m[0] - last
+		names = append(names, template[m[2]:m[3]])
+		last = m[1]
+	}
+	b.WriteString(regexp.QuoteMeta(template[last:]))
+	literal += len(template) - last
+
+	re, err := regexp.Compile("^" + b.String() + "$")
+	if err != nil {
+		return nil, 0, false
+	}
+
+	match := re.FindStringSubmatch(path)
+	if match == nil {
+		return nil, 0, false
+	}
+
+	values := make(map[string]string, len(names))
+	for n, name := range names {
+		values[name] = match[n+1]
+	}
+
+	return values, literal, true
+}
+
+// validateParameters validates the parameters of the request.
+func (d *Document) validateParameters(item *PathItem, op *Operation, r *cassette.Request, u *url.URL, pathParams map[string]string) []Violation {
+	// Operation parameters override the path parameters with the same
+	// name and location
+	params := make(map[string]*Parameter)
+	keys := make([]string, 0)
+	for _, list := range [][]*Parameter{item.Parameters, op.Parameters} {
+		for _, p := range list {
+			p, err := d.parameter(p)
+			if err != nil {
+				return []Violation{violation("request", "%s", err)}
+			}
+
+			key := p.In + "." + p.Name
+			if _, ok := params[key]; !ok {
+				keys = append(keys, key)
+			}
+			params[key] = p
+		}
+	}
+
+	problems := make([]Violation, 0)
+	query := u.Query()
+	cookies := (&http.Request{Header: r.Headers}).Cookies()
+	for _, key := range keys {
+		p := params[key]
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				v, _ = url.PathUnescape(v)
+				values = []string{v}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			// These headers are described by other fields of
+			// the operation, according to the specification
+			switch http.CanonicalHeaderKey(p.Name) {
+			case "Accept", "Content-Type", "Authorization":
+				continue
+			}
+			values = r.Headers.Values(p.Name)
+		case "cookie":
+			for _, c := range cookies {
+				if c.Name == p.Name {
+					values = append(values, c.Value)
+				}
+			}
+		}
+
+		location := "request." + key
+		if len(values) == 0 {
+			if p.Required || p.In == "path" {
+				problems = append(problems, violation(location, "missing required parameter"))
+			}
+			continue
+		}
+
+		problems = append(problems, d.validateValue(p.Schema, d.parseValue(p.Schema, values), location)...)
+	}
+
+	return problems
+}
+
+// validateRequestBody validates the body of the request.
+func (d *Document) validateRequestBody(op *Operation, r *cassette.Request) []Violation {
+	body, err := d.requestBody(op.RequestBody)
+	if err != nil {
+		return []Violation{violation("request.body", "%s", err)}
+	}
+
+	if body == nil {
+		return nil
+	}
+
+	if r.Body == "" {
+		if body.Required {
+			return []Violation{violation("request.body", "missing required body")}
+		}
+		return nil
+	}
+
+	return d.validateBody(body.Content, r.Headers.Get("Content-Type"), r.Body, "request.body")
+}
+
+// validateResponse validates the response against the declared response for
+// its status code.
+func (d *Document) validateResponse(op *Operation, r *cassette.Response, location string, template bool) []Violation {
+	declared := op.Responses[strconv.Itoa(r.Code)]
+	if declared == nil {
+		declared = op.Responses[fmt.Sprintf("%dXX", r.Code/100)]
+	}
+	if declared == nil {
+		declared = op.Responses["default"]
+	}
+	if declared == nil {
+		return []Violation{violation(location+".code", "status %d is not declared", r.Code)}
+	}
+
+	declared, err := d.response(declared)
+	if err != nil {
+		return []Violation{violation(location, "%s", err)}
+	}
+
+	if r.Body == "" || template {
+		return nil
+	}
+
+	if len(declared.Content) == 0 {
+		return []Violation{violation(location+".body", "body is not declared for status %d", r.Code)}
+	}
+
+	return d.validateBody(declared.Content, r.Headers.Get("Content-Type"), r.Body, location+".body")
+}
+
+// validateBody validates the body against the schema of its content type.
+// Only JSON and URL-encoded form bodies are validated against the schema.
+func (d *Document) validateBody(content map[string]*MediaType, contentType, body, location string) []Violation {
+	if contentType == "" {
+		return []Violation{violation(location, "missing Content-Type header")}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return []Violation{violation(location, "invalid content type %q", contentType)}
+	}
+
+	media := findMediaType(content, mediaType)
+	if media == nil {
+		return []Violation{violation(location, "content type %q is not declared", mediaType)}
+	}
+
+	if media.Schema == nil {
+		return nil
+	}
+
+	switch {
+	case isJSON(mediaType):
+		var v any
+		if err := json.Unmarshal([]byte(body), &v); err != nil {
+			return []Violation{violation(location, "invalid JSON: %s", err)}
+		}
+		return d.validateValue(media.Schema, v, location)
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return []Violation{violation(location, "invalid form: %s", err)}
+		}
+		return d.validateValue(media.Schema, d.parseForm(media.Schema, form), location)
+	default:
+		return nil
+	}
+}
+
+// parseForm converts the form values to an object, whose values have the
+// types of the properties of the given schema.
+func (d *Document) parseForm(s *Schema, form url.Values) map[string]any {
+	obj := make(map[string]any, len(form))
+	s, err := d.schema(s)
+	if err != nil || s == nil {
+		return obj
+	}
+
+	for name, values := range form {
+		obj[name] = d.parseValue(s.Properties[name], values)
+	}
+
+	return obj
+}
+
+// findMediaType returns the declared content for the given media type. Exact
+// matches take precedence over ranges, e.g. "application/*" and "*/*".
+func findMediaType(content map[string]*MediaType, mediaType string) *MediaType {
+	ranges := make(map[string]*MediaType)
+	for key, media := range content {
+		t, _, err := mime.ParseMediaType(key)
+		if err != nil {
+			continue
+		}
+		if t == mediaType {
+			return media
+		}
+		ranges[t] = media
+	}
+
+	major, _, _ := strings.Cut(mediaType, "/")
+	if media, ok := ranges[major+"/*"]; ok {
+		return media
+	}
+
+	return ranges["*/*"]
+}
+
+// isJSON returns true for JSON media types, e.g. "application/json" and
+// "application/problem+json".
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
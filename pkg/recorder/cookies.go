@@ -0,0 +1,122 @@
+package recorder
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// RedactedCookieValue is the value used by [RedactCookies] to replace cookie
+// values, when a rule does not specify a replacement.
+const RedactedCookieValue = "REDACTED"
+
+// CookieRedactionRule specifies the cookies, whose values are redacted by
+// [RedactCookies].
+type CookieRedactionRule struct {
+	// Name is the name of the cookies to redact. It may be a pattern as
+	// supported by [path.Match], e.g. "session*".
+	Name string
+
+	// Replacement is the value, which replaces the value of the cookie.
+	// If empty, [RedactedCookieValue] is used.
+	Replacement string
+}
+
+// replacement returns the value, which replaces the value of the cookie with
+// the given name, and a boolean indicating whether the rule applies to it.
+func (rule CookieRedactionRule) replacement(name string) (string, bool) {
+	if ok, err := path.Match(rule.Name, name); err != nil || !ok {
+		return "", false
+	}
+
+	if rule.Replacement == "" {
+		return RedactedCookieValue, true
+	}
+
+	return rule.Replacement, true
+}
+
+// RedactCookies returns a [HookFunc], which redacts the values of the cookies
+// matching the given rules from the Cookie request headers and the Set-Cookie
+// response headers of an interaction. The attributes of the Set-Cookie headers,
+// e.g. Path, Expires or HttpOnly are preserved as recorded.
+//
+// The hook should be used as a [BeforeSaveHook], so that the client keeps
+// using the real cookie values while recording. Since the replayed Set-Cookie
+// headers contain the redacted values, a client using a cookie jar sends the
+// redacted values back, and its requests match the redacted Cookie headers of
+// the following interactions.
+func RedactCookies(rules ...CookieRedactionRule) HookFunc {
+	redact := func(name, value string) string {
+		for _, rule := range rules {
+			if replacement, ok := rule.replacement(name); ok {
+				return replacement
+			}
+		}
+
+		return value
+	}
+
+	hook := func(i *cassette.Interaction) error {
+		redactCookieHeader(i.Request.Headers, redact)
+		redactSetCookieHeader(i.Response.Headers, redact)
+		for idx := range i.Sequence {
+			redactSetCookieHeader(i.Sequence[idx].Headers, redact)
+		}
+
+		return nil
+	}
+
+	return hook
+}
+
+// redactCookieHeader redacts the cookie values from the Cookie headers of the
+// given request header.
+func redactCookieHeader(header http.Header, redact func(name, value string) string) {
+	values := header["Cookie"]
+	for idx, value := range values {
+		pairs := strings.Split(value, ";")
+		for n, pair := range pairs {
+			pairs[n] = redactCookiePair(pair, redact)
+		}
+		values[idx] = strings.Join(pairs, ";")
+	}
+}
+
+// redactSetCookieHeader redacts the cookie values from the Set-Cookie headers of
+// the given response header, while keeping the cookie attributes intact.
+func redactSetCookieHeader(header http.Header, redact func(name, value string) string) {
+	values := header["Set-Cookie"]
+	for idx, value := range values {
+		pair, attrs, found := strings.Cut(value, ";")
+		pair = redactCookiePair(pair, redact)
+		if found {
+			pair += ";" + attrs
+		}
+		values[idx] = pair
+	}
+}
+
+// redactCookiePair redacts the value of a single name=value cookie pair, while
+// keeping the surrounding whitespace and quotes.
+func redactCookiePair(pair string, redact func(name, value string) string) string {
+	name, value, found := strings.Cut(pair, "=")
+	if !found {
+		return pair
+	}
+
+	trimmed := strings.TrimSpace(name)
+	quoted := len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`)
+	if quoted {
+		value = value[1 : len(value)-1]
+	}
+
+	redacted := redact(trimmed, value)
+	if quoted {
+		redacted = `"` + redacted + `"`
+	}
+
+	return name + "=" + redacted
+}
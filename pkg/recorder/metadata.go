@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"os"
+	"os/user"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// modulePath is the path of the go-vcr module
+const modulePath = "gopkg.in/dnaeon/go-vcr.v4"
+
+// updateMetadata updates the metadata of the cassette with the details of the
+// current recording.
+func (rec *Recorder) updateMetadata() {
+	rec.cassette.Lock()
+	defer rec.cassette.Unlock()
+
+	m := &rec.cassette.Metadata
+	m.RecordedAt = time.Now().UTC().Truncate(time.Second)
+	m.RecordedBy = currentUser()
+	m.GoVCRVersion = goVCRVersion()
+	m.Mode = rec.mode.String()
+	m.Matcher = describeFunc(rec.matcher)
+
+	if len(rec.labels) > 0 && m.Labels == nil {
+		m.Labels = make(map[string]string)
+	}
+	for k, v := range rec.labels {
+		m.Labels[k] = v
+	}
+}
+
+// currentUser returns the name of the current user.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// goVCRVersion returns the version of the go-vcr module, as recorded in the
+// build information of the running binary.
+func goVCRVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return ""
+}
+
+// describeFunc returns the name of the given function.
+func describeFunc(fn any) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return ""
+	}
+
+	// Method values have a "-fm" suffix
+	return strings.TrimSuffix(f.Name(), "-fm")
+}
@@ -1,63 +1,336 @@
 package recorder
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
 )
 
-// HTTPMiddleware intercepts and records all incoming requests and the server's response
+// HTTPMiddleware intercepts and records all incoming requests and the server's
+// response. The interactions are recorded according to the mode of the
+// recorder, i.e. always in [ModeRecordOnly], only into a new cassette in
+// [ModeRecordOnce], only if they are not in the cassette yet in
+// [ModeReplayWithNewEpisodes], and never in [ModeReplayOnly].
+//
+// The response writer passed to the next handler supports flushing, hijacking
+// the connection and HTTP/2 server push, if the original response writer
+// supports them, either directly or via [http.ResponseController]. Streamed
+// writes and trailers are recorded as part of the response. Responses written
+// to a hijacked connection cannot be recorded.
+//
+// The recorded URL uses the https scheme for requests received over TLS, and
+// the stable "go-vcr" host, since the host of the incoming requests usually
+// varies between runs. Use [WithMiddlewareRequestHost] in order to record the
+// actual host instead. Errors, which occur while recording are reported to the
+// handler configured using [WithMiddlewareErrorHandler].
+//
+// When replaying is enabled using [WithMiddlewareReplay], and an incoming
+// request matches a recorded interaction, which would be replayed by the
+// recorder in its current mode, e.g. in [ModeReplayOnly], the recorded response
+// is written to the client without invoking the next handler. This allows
+// standing up a stub of a service from a cassette recorded in front of the real
+// service. Requests, which cannot be replayed or recorded in the current mode
+// are served by the next handler, and the error is reported to the middleware
+// error handler.
 func (rec *Recorder) HTTPMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ww := newPassthrough(w)
-
-		// Tee the body so it can be read by the next handler and by the recorder
-		body := &bytes.Buffer{}
-		r.Body = io.NopCloser(io.TeeReader(r.Body, body))
+		if rec.isPassthrough(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		next.ServeHTTP(ww, r)
+		// Without replaying, the handler is always invoked, and the
+		// interaction is recorded according to the mode of the recorder
+		if !rec.middlewareReplay {
+			if req, resp, ok := rec.serve(w, r, next); ok {
+				if err := rec.recordServed(req, resp); err != nil {
+					rec.middlewareError(r, err)
+				}
+			}
+			return
+		}
 
-		r.Body = io.NopCloser(body)
+		// Tee the body while looking up the interaction, so that the
+		// part of the body read by the matcher is still available to the
+		// next handler
+		consumed := &bytes.Buffer{}
+		lookup := r.Clone(r.Context())
+		if r.Body != nil {
+			lookup.Body = io.NopCloser(io.TeeReader(r.Body, consumed))
+		}
+		rec.setRequestURL(lookup)
 
-		// On the server side, requests do not have Host and Scheme so it must be set
-		r.URL.Host = "go-vcr"
-		r.URL.Scheme = "http"
+		interaction, expired, err := rec.lookupInteraction(lookup)
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(consumed, r.Body), r.Body}
+		}
 
-		// copy headers from real response
-		for k, vv := range ww.real.Header() {
-			for _, v := range vv {
-				ww.recorder.Result().Header.Add(k, v)
+		switch {
+		case err != nil:
+			rec.middlewareError(r, err)
+			next.ServeHTTP(w, r)
+			return
+		case interaction != nil:
+			if err := rec.replayResponse(w, lookup, interaction); err != nil {
+				rec.middlewareError(r, err)
 			}
+			return
 		}
 
-		_, _ = rec.executeAndRecord(r, ww.recorder.Result())
+		if req, resp, ok := rec.serve(w, r, next); ok {
+			if _, err := rec.recordInteraction(req, resp, expired); err != nil {
+				rec.middlewareError(r, err)
+			}
+		}
 	})
 }
 
-var _ http.ResponseWriter = &passthroughWriter{}
+// serve serves the incoming request using the next handler, and returns the
+// request and the response of the handler to record. It returns false, if the
+// response cannot be recorded.
+func (rec *Recorder) serve(w http.ResponseWriter, r *http.Request, next http.Handler) (*http.Request, *http.Response, bool) {
+	ww := newMiddlewareWriter(w)
+
+	// Tee the body so it can be read by the next handler and by the recorder
+	body := &bytes.Buffer{}
+	if r.Body != nil {
+		r.Body = io.NopCloser(io.TeeReader(r.Body, body))
+	}
+
+	next.ServeHTTP(ww, r)
+
+	if ww.hijacked {
+		rec.middlewareError(r, ErrConnectionHijacked)
+		return nil, nil, false
+	}
+
+	// Record the remaining part of the body, which was not read by the
+	// handler
+	if r.Body != nil {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}
+
+	// The response has been sent already, so the request is recorded even
+	// if the client went away in the meantime
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Body = io.NopCloser(body)
+	rec.setRequestURL(req)
+
+	return req, ww.result(), true
+}
+
+// recordServed records the given request and the response of the next handler,
+// if the interaction is to be recorded in the current mode of the recorder.
+// Unlike [Recorder.requestHandler], the recorded interactions are never looked
+// up in order to be replayed.
+func (rec *Recorder) recordServed(r *http.Request, resp *http.Response) error {
+	switch {
+	case rec.mode == ModeRecordOnly, rec.mode == ModeRecordOnce && rec.cassette.IsNew:
+		_, err := rec.recordInteraction(r, resp, nil)
+		return err
+	case rec.mode == ModeReplayWithNewEpisodes:
+		interaction, expired, err := rec.lookupInteraction(r)
+		if err != nil || interaction != nil {
+			return err
+		}
+		_, err = rec.recordInteraction(r, resp, expired)
+		return err
+	default:
+		return nil
+	}
+}
+
+// replayResponse writes the recorded response of the given interaction to the
+// client.
+func (rec *Recorder) replayResponse(w http.ResponseWriter, r *http.Request, interaction *cassette.Interaction) error {
+	if err := rec.applyHooks(interaction, BeforeResponseReplayHook); err != nil {
+		return err
+	}
+
+	resp, err := interaction.RenderHTTPResponse(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	header := w.Header()
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			header.Add(k, v)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+
+	// Trailers, which were not declared in the Trailer header are sent
+	// using the trailer prefix
+	declared := make(map[string]bool)
+	for _, v := range header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+
+	for k, vv := range resp.Trailer {
+		if !declared[k] {
+			k = http.TrailerPrefix + k
+		}
+		header[k] = vv
+	}
+
+	return nil
+}
+
+// middlewareError reports an error, which occurred while recording the given
+// incoming request.
+func (rec *Recorder) middlewareError(r *http.Request, err error) {
+	switch {
+	case rec.middlewareErrorHandler != nil:
+		rec.middlewareErrorHandler(r, err)
+	case rec.tb != nil:
+		rec.tb.Errorf("go-vcr: failed to record %s %s: %s", r.Method, r.URL, err)
+	}
+}
+
+// setRequestURL sets the host and scheme of the URL of an incoming request,
+// since on the server side they are usually not part of the URL.
+func (rec *Recorder) setRequestURL(r *http.Request) {
+	if rec.middlewareRequestHost && r.URL.Host == "" {
+		r.URL.Host = r.Host
+	}
+	if r.URL.Host == "" {
+		r.URL.Host = "go-vcr"
+	}
+
+	if r.URL.Scheme == "" {
+		r.URL.Scheme = "http"
+		if r.TLS != nil {
+			r.URL.Scheme = "https"
+		}
+	}
+}
+
+var _ http.ResponseWriter = &middlewareWriter{}
+var _ http.Flusher = &middlewareWriter{}
+var _ http.Hijacker = &middlewareWriter{}
+var _ http.Pusher = &middlewareWriter{}
 
-// passthroughWriter uses the original ResponseWriter and an httptest.ResponseRecorder
-// so the middleware can capture response details and passthrough to the client
-type passthroughWriter struct {
+// middlewareWriter writes the response to the original ResponseWriter, while
+// capturing it using an httptest.ResponseRecorder, so that the middleware can
+// record the response as it was sent to the client.
+type middlewareWriter struct {
+	// real is the original ResponseWriter
+	real http.ResponseWriter
+
+	// recorder captures the response
 	recorder *httptest.ResponseRecorder
-	real     http.ResponseWriter
+
+	// wroteHeader is true, once the header of the response was written
+	wroteHeader bool
+
+	// hijacked is true, if the handler hijacked the connection
+	hijacked bool
+}
+
+func newMiddlewareWriter(real http.ResponseWriter) *middlewareWriter {
+	return &middlewareWriter{recorder: httptest.NewRecorder(), real: real}
+}
+
+func (w *middlewareWriter) Header() http.Header {
+	return w.real.Header()
+}
+
+func (w *middlewareWriter) Write(in []byte) (int, error) {
+	w.snapshotHeader()
+	n, err := w.real.Write(in)
+	_, _ = w.recorder.Write(in[:n])
+
+	return n, err
 }
 
-func newPassthrough(real http.ResponseWriter) passthroughWriter {
-	return passthroughWriter{recorder: httptest.NewRecorder(), real: real}
+func (w *middlewareWriter) WriteHeader(statusCode int) {
+	// Informational responses are sent before the final header, and
+	// are not recorded
+	if statusCode >= 200 {
+		w.snapshotHeader()
+		w.recorder.WriteHeader(statusCode)
+	}
+	w.real.WriteHeader(statusCode)
 }
 
-func (p passthroughWriter) Header() http.Header {
-	return p.real.Header()
+// FlushError flushes the buffered data to the client, and returns an error if
+// the original ResponseWriter does not support flushing.
+func (w *middlewareWriter) FlushError() error {
+	w.snapshotHeader()
+	w.recorder.Flush()
+
+	return http.NewResponseController(w.real).Flush()
 }
 
-func (p passthroughWriter) Write(in []byte) (int, error) {
-	_, _ = p.recorder.Write(in)
-	return p.real.Write(in)
+func (w *middlewareWriter) Flush() {
+	_ = w.FlushError()
 }
 
-func (p passthroughWriter) WriteHeader(statusCode int) {
-	p.recorder.WriteHeader(statusCode)
-	p.real.WriteHeader(statusCode)
+func (w *middlewareWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.real).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+
+	return conn, rw, err
+}
+
+func (w *middlewareWriter) Push(target string, opts *http.PushOptions) error {
+	rw := w.real
+	for {
+		if pusher, ok := rw.(http.Pusher); ok {
+			return pusher.Push(target, opts)
+		}
+
+		unwrapper, ok := rw.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return http.ErrNotSupported
+		}
+		rw = unwrapper.Unwrap()
+	}
+}
+
+// Unwrap returns the original ResponseWriter, which is used by
+// [http.ResponseController] to access the methods, which are not implemented
+// by the middleware writer, e.g. SetWriteDeadline.
+func (w *middlewareWriter) Unwrap() http.ResponseWriter {
+	return w.real
+}
+
+// snapshotHeader copies the header of the original ResponseWriter to the
+// recorder, right before the header of the response is written.
+func (w *middlewareWriter) snapshotHeader() {
+	if w.wroteHeader {
+		return
+	}
+
+	w.recorder.HeaderMap = w.real.Header().Clone()
+	w.wroteHeader = true
+}
+
+// result returns the recorded response. The trailers of the response are
+// taken from the final header of the original ResponseWriter.
+func (w *middlewareWriter) result() *http.Response {
+	w.snapshotHeader()
+	w.recorder.HeaderMap = w.real.Header().Clone()
+
+	return w.recorder.Result()
 }
@@ -34,6 +34,9 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
 	"time"
 
 	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
@@ -89,6 +92,43 @@ const (
 // mode
 var ErrInvalidMode = errors.New("invalid recorder mode")
 
+// ModeEnv is the name of the environment variable, which overrides the mode of
+// all recorders configured using [WithModeFromEnv], when set. This allows for
+// re-recording an entire test suite without changing any code, e.g.
+// GO_VCR_MODE=record go test ./...
+const ModeEnv = "GO_VCR_MODE"
+
+// modeNames maps the recorder modes to their names
+var modeNames = map[Mode]string{
+	ModeRecordOnly:            "record",
+	ModeReplayOnly:            "replay",
+	ModeReplayWithNewEpisodes: "new_episodes",
+	ModeRecordOnce:            "once",
+	ModePassthrough:           "passthrough",
+}
+
+// String returns the name of the mode.
+func (m Mode) String() string {
+	if name, ok := modeNames[m]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// ParseMode parses the name of a recorder mode. Valid mode names are record,
+// replay, new_episodes, once and passthrough.
+func ParseMode(name string) (Mode, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for mode, modeName := range modeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+
+	return Mode(-1), fmt.Errorf("%w: %q", ErrInvalidMode, name)
+}
+
 // HookFunc represents a function, which will be invoked in different stages of
 // the playback. The hook functions allow for plugging in to the playback and
 // transform an interaction, if needed. For example a hook function might redact
@@ -140,6 +180,23 @@ func NewHook(handler HookFunc, kind HookKind) *Hook {
 	return hook
 }
 
+// DeduplicateMode specifies how identical interactions are collapsed when
+// saving the cassette.
+type DeduplicateMode int
+
+const (
+	// DeduplicateNone keeps all recorded interactions.
+	DeduplicateNone DeduplicateMode = iota
+
+	// DeduplicateCounted collapses identical interactions into a single
+	// one, which is replayed as many times as it was recorded.
+	DeduplicateCounted
+
+	// DeduplicateUnlimited collapses identical interactions into a single
+	// one, which may be replayed any number of times.
+	DeduplicateUnlimited
+)
+
 // PassthroughFunc is a predicate which determines whether a specific HTTP
 // request is to be forwarded to the original endpoint. It should return true
 // when a request needs to be passed through, and false otherwise.
@@ -150,6 +207,29 @@ type PassthroughFunc func(req *http.Request) bool
 // are defined as part of RFC 9110, section 9.2.1.
 var ErrUnsafeRequestMethod = errors.New("request uses an unsafe method")
 
+// ErrUnusedInteractions is returned by [Recorder.Stop], when the [Recorder] was
+// configured to fail on unused interactions, and the cassette contains
+// interactions, which were never replayed.
+var ErrUnusedInteractions = errors.New("cassette contains unused interactions")
+
+// ErrInteractionExpired is returned when replaying an interaction, which is
+// older than the maximum age configured for the [Recorder], and the recorder is
+// running in a mode, which does not allow re-recording it.
+var ErrInteractionExpired = errors.New("requested interaction has expired")
+
+// ErrConnectionHijacked is reported by [Recorder.HTTPMiddleware], when the
+// handler hijacked the connection, and the response could not be recorded.
+var ErrConnectionHijacked = errors.New("connection was hijacked by the handler")
+
+// MiddlewareErrorFunc is a function, which is invoked by
+// [Recorder.HTTPMiddleware] with the incoming request, when recording the
+// request failed.
+type MiddlewareErrorFunc func(r *http.Request, err error)
+
+// UnusedInteractionsFunc is a function, which is invoked by [Recorder.Stop]
+// with the interactions from the cassette, which were never replayed.
+type UnusedInteractionsFunc func(unused []*cassette.Interaction)
+
 type blockUnsafeMethodsRoundTripper struct {
 	RoundTripper http.RoundTripper
 }
@@ -179,6 +259,10 @@ type Recorder struct {
 	// mode is the mode of the recorder
 	mode Mode
 
+	// modeEnv is the name of the environment variable, from which to read
+	// the mode of the recorder.
+	modeEnv string
+
 	// RealTransport is the underlying http.RoundTripper to make
 	// the real requests
 	realTransport http.RoundTripper
@@ -207,6 +291,85 @@ type Recorder struct {
 	// replayableInteractions specifies whether to allow interactions to be
 	// replayed multiple times.
 	replayableInteractions bool
+
+	// failOnUnusedInteractions specifies whether stopping the recorder
+	// should fail, if the cassette contains interactions, which were never
+	// replayed.
+	failOnUnusedInteractions bool
+
+	// unusedInteractionsHandlers are invoked when stopping the recorder
+	// with the interactions, which were never replayed.
+	unusedInteractionsHandlers []UnusedInteractionsFunc
+
+	// pruneUnusedInteractions specifies whether interactions, which were
+	// never replayed should be removed from the cassette, when it is saved
+	// on disk.
+	pruneUnusedInteractions bool
+
+	// strictOrdering specifies whether interactions must be replayed in
+	// the sequence in which they were recorded.
+	strictOrdering bool
+
+	// orderingGroup is the [cassette.OrderingGroupFunc] used to split
+	// interactions into independently ordered groups.
+	orderingGroup cassette.OrderingGroupFunc
+
+	// maxAge is the maximum age of recorded interactions, after which they
+	// are considered expired.
+	maxAge time.Duration
+
+	// tb is the test associated with the recorder, when it was created
+	// using [NewForTest].
+	tb testing.TB
+
+	// deduplicate specifies how identical interactions are collapsed when
+	// saving the cassette.
+	deduplicate DeduplicateMode
+
+	// deduplicateIgnoreHeaders are the response headers, which are ignored
+	// in addition to [cassette.VolatileHeaders] when deduplicating
+	// interactions
+	deduplicateIgnoreHeaders []string
+
+	// sequences specifies what happens once all responses from a sequence
+	// have been replayed. Sequences of responses are created when saving
+	// the cassette only if set.
+	sequences cassette.SequenceBehavior
+
+	// metadata specifies whether to update the metadata of the cassette,
+	// when saving it.
+	metadata bool
+
+	// labels are the user labels added to the metadata of the cassette.
+	labels map[string]string
+
+	// testName is the name of the test, which is recorded with the
+	// interactions.
+	testName string
+
+	// recorded is true, when new interactions were recorded into the
+	// cassette.
+	recorded atomic.Bool
+
+	// sharedCassetteNames are the names of the read-only cassettes, which
+	// are consulted before the cassette of the recorder.
+	sharedCassetteNames []string
+
+	// sharedCassettes are the loaded read-only cassettes, in the order in
+	// which they are consulted.
+	sharedCassettes []*cassette.Cassette
+
+	// middlewareErrorHandler is invoked by the HTTP middleware, when
+	// recording an incoming request failed.
+	middlewareErrorHandler MiddlewareErrorFunc
+
+	// middlewareReplay specifies whether the HTTP middleware replays the
+	// recorded responses instead of invoking the next handler.
+	middlewareReplay bool
+
+	// middlewareRequestHost specifies whether the HTTP middleware records
+	// the host of the incoming requests in their URL.
+	middlewareRequestHost bool
 }
 
 // Option is a function which configures the [Recorder].
@@ -222,6 +385,20 @@ func WithMode(mode Mode) Option {
 	return opt
 }
 
+// WithModeFromEnv is an [Option], which configures the [Recorder] to run in
+// the mode specified by the given environment variable. See [ParseMode] for the
+// valid mode names. The mode configured by [WithMode] or the default mode is
+// used, when the environment variable is not set. The [ModeEnv] environment
+// variable takes precedence over the given one. The environment is not
+// consulted by recorders created without this option.
+func WithModeFromEnv(name string) Option {
+	opt := func(r *Recorder) {
+		r.modeEnv = name
+	}
+
+	return opt
+}
+
 // WithRealTransport is an [Option], which configures the [Recorder] to use the
 // specified [http.RoundTripper] when making actual HTTP requests.
 func WithRealTransport(rt http.RoundTripper) Option {
@@ -300,24 +477,252 @@ func WithReplayableInteractions(val bool) Option {
 	return opt
 }
 
+// WithFailOnUnusedInteractions is an [Option], which configures the [Recorder]
+// to return an error from [Recorder.Stop], if the cassette contains
+// interactions, which were never replayed. This is useful for detecting stale
+// interactions, which are no longer used by the code under test.
+func WithFailOnUnusedInteractions(val bool) Option {
+	opt := func(r *Recorder) {
+		r.failOnUnusedInteractions = val
+	}
+
+	return opt
+}
+
+// WithUnusedInteractionsHandler is an [Option], which configures the
+// [Recorder] to invoke the provided [UnusedInteractionsFunc] from
+// [Recorder.Stop], if the cassette contains interactions, which were never
+// replayed.
+func WithUnusedInteractionsHandler(handler UnusedInteractionsFunc) Option {
+	opt := func(r *Recorder) {
+		r.unusedInteractionsHandlers = append(r.unusedInteractionsHandlers, handler)
+	}
+
+	return opt
+}
+
+// WithPruneUnusedInteractions is an [Option], which configures the [Recorder]
+// to remove interactions, which were never replayed, from the cassette when it
+// is saved on disk. Pruning happens only in modes, which save the cassette.
+func WithPruneUnusedInteractions(val bool) Option {
+	opt := func(r *Recorder) {
+		r.pruneUnusedInteractions = val
+	}
+
+	return opt
+}
+
+// WithStrictOrdering is an [Option], which configures the [Recorder] to replay
+// interactions in the sequence in which they were recorded. A request, which
+// matches an interaction other than the next one in the sequence results in a
+// [cassette.ErrInteractionOutOfOrder] error.
+func WithStrictOrdering(val bool) Option {
+	opt := func(r *Recorder) {
+		r.strictOrdering = val
+	}
+
+	return opt
+}
+
+// WithOrderingGroup is an [Option], which configures the [Recorder] to enforce
+// strict ordering of interactions within the groups returned by the provided
+// [cassette.OrderingGroupFunc], e.g. [cassette.GroupByHost], instead of
+// globally. It implies [WithStrictOrdering].
+func WithOrderingGroup(group cassette.OrderingGroupFunc) Option {
+	opt := func(r *Recorder) {
+		r.strictOrdering = true
+		r.orderingGroup = group
+	}
+
+	return opt
+}
+
+// WithMaxAge is an [Option], which configures the maximum age of recorded
+// interactions. When running in [ModeReplayWithNewEpisodes] or [ModeRecordOnce]
+// expired interactions are transparently re-recorded using the real transport,
+// while fresh ones are replayed. In [ModeReplayOnly] replaying an expired
+// interaction results in an [ErrInteractionExpired] error. The age of
+// interactions without a recorded time, e.g. those of cassettes recorded by
+// older versions, is unknown, and they never expire.
+func WithMaxAge(maxAge time.Duration) Option {
+	opt := func(r *Recorder) {
+		r.maxAge = maxAge
+	}
+
+	return opt
+}
+
+// WithSharedCassettes is an [Option], which configures the [Recorder] to
+// consult the given read-only cassettes, in order, before its own cassette when
+// replaying interactions. This is useful for sharing common interactions, e.g.
+// authentication, between tests. New interactions are recorded only into the
+// cassette of the recorder, and shared cassettes are never saved. The shared
+// cassettes are not consulted in [ModeRecordOnly] and [ModePassthrough], in
+// which nothing is replayed. The shared cassettes must exist, otherwise [New]
+// returns [cassette.ErrCassetteNotFound].
+func WithSharedCassettes(names ...string) Option {
+	opt := func(r *Recorder) {
+		r.sharedCassetteNames = append(r.sharedCassetteNames, names...)
+	}
+
+	return opt
+}
+
+// WithDeduplicateInteractions is an [Option], which configures the [Recorder]
+// to collapse consecutive interactions with identical request and response into
+// a single interaction, when saving the cassette. This is useful for keeping
+// cassettes small, when the same endpoint is hit many times in a row, e.g.
+// health checks. The [cassette.VolatileHeaders] of the responses, e.g. Date,
+// are ignored when comparing the interactions. Collapsing happens after the
+// [BeforeSaveHook] hooks have been applied.
+func WithDeduplicateInteractions(mode DeduplicateMode) Option {
+	opt := func(r *Recorder) {
+		r.deduplicate = mode
+	}
+
+	return opt
+}
+
+// WithDeduplicateIgnoreHeaders is an [Option], which configures the [Recorder]
+// to ignore the given response headers in addition to the
+// [cassette.VolatileHeaders], when deduplicating interactions.
+func WithDeduplicateIgnoreHeaders(names ...string) Option {
+	opt := func(r *Recorder) {
+		r.deduplicateIgnoreHeaders = append(r.deduplicateIgnoreHeaders, names...)
+	}
+
+	return opt
+}
+
+// WithResponseSequences is an [Option], which configures the [Recorder] to
+// collapse consecutive interactions with identical requests, but different
+// responses into a single interaction with a sequence of responses, when saving
+// the cassette. This is useful for polling endpoints, which return different
+// responses to the same request over time. The provided [cassette.SequenceBehavior] specifies
+// what happens once all responses from the sequence have been replayed.
+// Collapsing happens after the [BeforeSaveHook] hooks have been applied.
+func WithResponseSequences(after cassette.SequenceBehavior) Option {
+	opt := func(r *Recorder) {
+		r.sequences = after
+	}
+
+	return opt
+}
+
+// WithMetadata is an [Option], which configures the [Recorder] whether to update
+// the metadata of the cassette, when new interactions are recorded into it. The
+// metadata is not updated by default, since it changes whenever the cassette is
+// recorded, and contains the name of the user, who recorded it.
+func WithMetadata(val bool) Option {
+	opt := func(r *Recorder) {
+		r.metadata = val
+	}
+
+	return opt
+}
+
+// WithTestName is an [Option], which configures the [Recorder] to record the
+// given test name with each new interaction, so that a cassette shared by
+// several tests can be split by test using [cassette.SplitByTest]. Recorders
+// created using [NewForTest] use the name of the test.
+func WithTestName(name string) Option {
+	opt := func(r *Recorder) {
+		r.testName = name
+	}
+
+	return opt
+}
+
+// WithLabels is an [Option], which configures the [Recorder] to add the given
+// free-form labels to the metadata of the cassette, when the metadata is updated
+// using [WithMetadata].
+func WithLabels(labels map[string]string) Option {
+	opt := func(r *Recorder) {
+		for k, v := range labels {
+			r.labels[k] = v
+		}
+	}
+
+	return opt
+}
+
+// WithMiddlewareErrorHandler is an [Option], which configures the [Recorder] to
+// invoke the given handler, when [Recorder.HTTPMiddleware] fails to record an
+// incoming request. By default such errors are reported to the test, when the
+// recorder was created using [NewForTest], and are ignored otherwise.
+func WithMiddlewareErrorHandler(handler MiddlewareErrorFunc) Option {
+	opt := func(r *Recorder) {
+		r.middlewareErrorHandler = handler
+	}
+
+	return opt
+}
+
+// WithMiddlewareReplay is an [Option], which configures
+// [Recorder.HTTPMiddleware] to write the recorded responses of the incoming
+// requests, which match a recorded interaction, to the client instead of
+// invoking the next handler. Use [cassette.ServerMatcher] to match incoming
+// requests, since their remote address and host vary between runs.
+func WithMiddlewareReplay(val bool) Option {
+	opt := func(r *Recorder) {
+		r.middlewareReplay = val
+	}
+
+	return opt
+}
+
+// WithMiddlewareRequestHost is an [Option], which configures
+// [Recorder.HTTPMiddleware] to record the URL of incoming requests with their
+// actual host, e.g. "127.0.0.1:8080", instead of the stable "go-vcr" host.
+func WithMiddlewareRequestHost(val bool) Option {
+	opt := func(r *Recorder) {
+		r.middlewareRequestHost = val
+	}
+
+	return opt
+}
+
 // New creates a new [Recorder] and configures it using the provided options.
 func New(cassetteName string, opts ...Option) (*Recorder, error) {
 	r := &Recorder{
-		cassetteName:           cassetteName,
-		mode:                   ModeRecordOnce,
-		realTransport:          http.DefaultTransport,
-		passthroughs:           make([]PassthroughFunc, 0),
-		hooks:                  make([]*Hook, 0),
-		blockUnsafeMethods:     false,
-		skipRequestLatency:     false,
-		matcher:                cassette.DefaultMatcher,
-		replayableInteractions: false,
+		cassetteName:               cassetteName,
+		mode:                       ModeRecordOnce,
+		realTransport:              http.DefaultTransport,
+		passthroughs:               make([]PassthroughFunc, 0),
+		hooks:                      make([]*Hook, 0),
+		blockUnsafeMethods:         false,
+		skipRequestLatency:         false,
+		matcher:                    cassette.DefaultMatcher,
+		replayableInteractions:     false,
+		failOnUnusedInteractions:   false,
+		unusedInteractionsHandlers: make([]UnusedInteractionsFunc, 0),
+		pruneUnusedInteractions:    false,
+		strictOrdering:             false,
+		deduplicate:                DeduplicateNone,
+		metadata:                   false,
+		labels:                     make(map[string]string),
+		sharedCassetteNames:        make([]string, 0),
+		sharedCassettes:            make([]*cassette.Cassette, 0),
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
+	// Environment variables take precedence over the configured mode, when
+	// selecting the mode from the environment was requested
+	if r.modeEnv != "" {
+		for _, name := range []string{r.modeEnv, ModeEnv} {
+			if val, ok := os.LookupEnv(name); ok && val != "" {
+				mode, err := ParseMode(val)
+				if err != nil {
+					return nil, err
+				}
+				r.mode = mode
+			}
+		}
+	}
+
 	// Configure the cassette based on the recorder configuration
 	c, err := r.getCassette()
 	if err != nil {
@@ -326,10 +731,35 @@ func New(cassetteName string, opts ...Option) (*Recorder, error) {
 	r.cassette = c
 	r.cassette.Matcher = r.matcher
 	r.cassette.ReplayableInteractions = r.replayableInteractions
+	r.cassette.StrictOrdering = r.strictOrdering
+	r.cassette.OrderingGroup = r.orderingGroup
+
+	// Shared cassettes are not used, when nothing is replayed
+	if r.mode != ModePassthrough && r.mode != ModeRecordOnly {
+		for _, name := range r.sharedCassetteNames {
+			c, err := loadSharedCassette(name)
+			if err != nil {
+				return nil, err
+			}
+			c.Matcher = r.matcher
+			c.ReplayableInteractions = r.replayableInteractions
+			r.sharedCassettes = append(r.sharedCassettes, c)
+		}
+	}
 
 	return r, nil
 }
 
+// loadSharedCassette loads the shared cassette with the given name.
+func loadSharedCassette(name string) (*cassette.Cassette, error) {
+	c, err := cassette.Load(name)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", cassette.ErrCassetteNotFound, cassette.New(name).File)
+	}
+
+	return c, err
+}
+
 // getCassette creates a new [*cassette.Cassette], or loads an already existing
 // one depending on the mode of the recorder.
 func (rec *Recorder) getCassette() (*cassette.Cassette, error) {
@@ -382,27 +812,59 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 		return nil, err
 	}
 
+	interaction, expired, err := rec.lookupInteraction(r)
+	if err != nil || interaction != nil {
+		return interaction, err
+	}
+
+	return rec.recordInteraction(r, serverResponse, expired)
+}
+
+// lookupInteraction looks up the recorded interaction for the given HTTP
+// request according to the mode of the recorder. It returns the interaction to
+// replay, if there is one. Otherwise, when the request needs to be recorded,
+// both the interaction and the error are nil, and expired is the interaction,
+// which will be replaced by the newly recorded one, if any.
+func (rec *Recorder) lookupInteraction(r *http.Request) (interaction, expired *cassette.Interaction, err error) {
+	// Shared cassettes are consulted first. They are loaded only in the
+	// modes, which replay interactions.
+	shared, err := rec.getSharedInteraction(r)
+	if err == nil {
+		return shared, nil, nil
+	} else if err != cassette.ErrInteractionNotFound {
+		return nil, nil, err
+	}
+
 	switch {
 	case rec.mode == ModeReplayOnly:
-		return rec.cassette.GetInteraction(r)
+		interaction, err := rec.getInteraction(r)
+		return interaction, nil, err
 	case rec.mode == ModeReplayWithNewEpisodes:
 		interaction, err := rec.cassette.GetInteraction(r)
-		if err == nil {
+		if err == nil && rec.isExpired(interaction) {
+			// Interaction found, but it needs to be re-recorded
+			return nil, interaction, nil
+		} else if err == nil {
 			// Interaction found, return it
-			return interaction, nil
+			return interaction, nil, nil
 		} else if err == cassette.ErrInteractionNotFound {
 			// Interaction not found, we have a new episode
-			break
+			return nil, nil, nil
 		} else {
 			// Any other error is an error
-			return nil, err
+			return nil, nil, err
 		}
 	case rec.mode == ModeRecordOnce && !rec.cassette.IsNew:
-		// We've got an existing cassette, return what we've got
-		return rec.cassette.GetInteraction(r)
+		// We've got an existing cassette, return what we've got, and
+		// refresh the expired interactions
+		interaction, err := rec.cassette.GetInteraction(r)
+		if err == nil && rec.isExpired(interaction) {
+			return nil, interaction, nil
+		}
+		return interaction, nil, err
 	case rec.mode == ModePassthrough:
 		// Passthrough requests always hit the original endpoint
-		break
+		return nil, nil, nil
 	case (rec.mode == ModeRecordOnly || rec.mode == ModeRecordOnce) && rec.cassette.ReplayableInteractions:
 		// When running with replayable interactions look for existing
 		// interaction first, so we avoid hitting multiple times the
@@ -410,19 +872,25 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 		interaction, err := rec.cassette.GetInteraction(r)
 		if err == nil {
 			// Interaction found, return it
-			return interaction, nil
+			return interaction, nil, nil
 		} else if err == cassette.ErrInteractionNotFound {
 			// Interaction not found, we have to record it
-			break
+			return nil, nil, nil
 		} else {
 			// Any other error is an error
-			return nil, err
+			return nil, nil, err
 		}
 	default:
 		// Anything else hits the original endpoint
-		break
+		return nil, nil, nil
 	}
+}
 
+// recordInteraction performs the given HTTP request and records the
+// interaction into the cassette. If serverResponse is provided, it is used for
+// the recording instead of performing the request. If expired is not nil, the
+// newly recorded interaction replaces it.
+func (rec *Recorder) recordInteraction(r *http.Request, serverResponse *http.Response, expired *cassette.Interaction) (*cassette.Interaction, error) {
 	// Copy the original request, so we can read the form values
 	reqBytes, err := httputil.DumpRequestOut(r, true)
 	if err != nil {
@@ -455,9 +923,10 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 	// If serverResponse is provided, use it instead
 	var start time.Time
 	start = time.Now()
+	trace := newTimingTrace(start)
 	resp := serverResponse
 	if resp == nil {
-		resp, err = rec.getRoundTripper().RoundTrip(r)
+		resp, err = rec.getRoundTripper().RoundTrip(r.WithContext(trace.withContext(r.Context())))
 		if err != nil {
 			return nil, err
 		}
@@ -469,9 +938,13 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 	if err != nil {
 		return nil, err
 	}
+	trace.record(&trace.bodyTransferred)
 
 	// Add interaction to the cassette
 	interaction := &cassette.Interaction{
+		ParentID:   redirectParentID(r),
+		Test:       rec.testName,
+		RecordedAt: start,
 		Request: cassette.Request{
 			Proto:            r.Proto,
 			ProtoMajor:       r.ProtoMajor,
@@ -501,6 +974,8 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 			Body:             string(respBody),
 			Headers:          resp.Header,
 			Duration:         requestDuration,
+			Timing:           trace.timing(),
+			TLS:              cassette.NewTLS(resp.TLS),
 		},
 	}
 
@@ -510,11 +985,79 @@ func (rec *Recorder) requestHandler(r *http.Request, serverResponse *http.Respon
 		return nil, err
 	}
 
-	rec.cassette.AddInteraction(interaction)
+	if expired != nil {
+		rec.cassette.ReplaceInteraction(expired, interaction)
+	} else {
+		rec.cassette.AddInteraction(interaction)
+	}
+	rec.recorded.Store(true)
 
 	return interaction, nil
 }
 
+// getInteraction retrieves the recorded interaction for the given HTTP request
+// from the cassette, and verifies that it has not expired.
+func (rec *Recorder) getInteraction(r *http.Request) (*cassette.Interaction, error) {
+	interaction, err := rec.cassette.GetInteraction(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.isExpired(interaction) {
+		return nil, fmt.Errorf(
+			"%w: [%d] %s %s recorded at %s",
+			ErrInteractionExpired,
+			interaction.ID,
+			interaction.Request.Method,
+			interaction.Request.URL,
+			interaction.RecordedAt.Format(time.RFC3339),
+		)
+	}
+
+	return interaction, nil
+}
+
+// getSharedInteraction retrieves the recorded interaction for the given HTTP
+// request from the shared cassettes. Expired interactions cannot be re-recorded
+// into the shared cassettes, and result in an [ErrInteractionExpired] error.
+func (rec *Recorder) getSharedInteraction(r *http.Request) (*cassette.Interaction, error) {
+	for _, c := range rec.sharedCassettes {
+		interaction, err := c.GetInteraction(r)
+		if err == cassette.ErrInteractionNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if rec.isExpired(interaction) {
+			return nil, fmt.Errorf(
+				"%w: [%d] %s %s recorded at %s in shared cassette %s",
+				ErrInteractionExpired,
+				interaction.ID,
+				interaction.Request.Method,
+				interaction.Request.URL,
+				interaction.RecordedAt.Format(time.RFC3339),
+				c.File,
+			)
+		}
+
+		return interaction, nil
+	}
+
+	return nil, cassette.ErrInteractionNotFound
+}
+
+// isExpired returns true, if the given interaction is older than the maximum
+// age configured for the recorder. Interactions without a recorded time have an
+// unknown age, and are not considered expired.
+func (rec *Recorder) isExpired(i *cassette.Interaction) bool {
+	if rec.maxAge <= 0 || i.RecordedAt.IsZero() {
+		return false
+	}
+
+	return time.Since(i.RecordedAt) > rec.maxAge
+}
+
 // Stop is used to stop the recorder and save any recorded
 // interactions if running in one of the recording modes. When
 // running in ModePassthrough no cassette will be saved on disk.
@@ -523,6 +1066,20 @@ func (rec *Recorder) Stop() error {
 	_, err := os.Stat(cassetteFile)
 	cassetteExists := !os.IsNotExist(err)
 
+	// Interactions are never replayed in ModePassthrough
+	unused := make([]*cassette.Interaction, 0)
+	if rec.mode != ModePassthrough {
+		unused = rec.cassette.UnusedInteractions()
+	}
+
+	pruned := false
+	if rec.pruneUnusedInteractions {
+		for _, interaction := range unused {
+			interaction.DiscardOnSave = true
+			pruned = true
+		}
+	}
+
 	// Nothing to do for ModeReplayOnly and ModePassthrough here
 	switch {
 	case rec.mode == ModeRecordOnly || rec.mode == ModeReplayWithNewEpisodes:
@@ -530,7 +1087,9 @@ func (rec *Recorder) Stop() error {
 			return err
 		}
 
-	case rec.mode == ModeRecordOnce && !cassetteExists:
+	case rec.mode == ModeRecordOnce && (!cassetteExists || rec.recorded.Load() || pruned):
+		// Existing cassettes are saved, when expired interactions were
+		// refreshed, or unused interactions were pruned
 		if err := rec.persistCassette(); err != nil {
 			return err
 		}
@@ -543,9 +1102,32 @@ func (rec *Recorder) Stop() error {
 		}
 	}
 
+	if len(unused) == 0 {
+		return nil
+	}
+
+	for _, handler := range rec.unusedInteractionsHandlers {
+		handler(unused)
+	}
+
+	if rec.failOnUnusedInteractions {
+		return fmt.Errorf("%w: %s", ErrUnusedInteractions, describeInteractions(unused))
+	}
+
 	return nil
 }
 
+// describeInteractions returns a short human-readable description of the
+// given interactions.
+func describeInteractions(interactions []*cassette.Interaction) string {
+	items := make([]string, 0, len(interactions))
+	for _, i := range interactions {
+		items = append(items, fmt.Sprintf("[%d] %s %s", i.ID, i.Request.Method, i.Request.URL))
+	}
+
+	return strings.Join(items, ", ")
+}
+
 // persisteCassette persists the cassette on disk for future re-use
 func (rec *Recorder) persistCassette() error {
 	// Apply any before-save hooks
@@ -555,6 +1137,21 @@ func (rec *Recorder) persistCassette() error {
 		}
 	}
 
+	if rec.sequences != "" {
+		rec.cassette.GroupSequences(rec.sequences)
+	}
+
+	if rec.metadata && (rec.cassette.IsNew || rec.recorded.Load()) {
+		rec.updateMetadata()
+	}
+
+	switch rec.deduplicate {
+	case DeduplicateCounted:
+		rec.cassette.Deduplicate(false, rec.deduplicateIgnoreHeaders...)
+	case DeduplicateUnlimited:
+		rec.cassette.Deduplicate(true, rec.deduplicateIgnoreHeaders...)
+	}
+
 	return rec.cassette.Save()
 }
 
@@ -579,20 +1176,16 @@ func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
 
 // executeAndRecord is used internally by the HTTPMiddleware to allow recording a response on the server side
 func (rec *Recorder) executeAndRecord(req *http.Request, serverResponse *http.Response) (*http.Response, error) {
-	// Passthrough mode, use real transport
-	if rec.mode == ModePassthrough {
+	// Passthrough requests use the real transport
+	if rec.isPassthrough(req) {
 		return rec.getRoundTripper().RoundTrip(req)
 	}
 
-	// Apply passthrough handler functions
-	for _, passthroughFunc := range rec.passthroughs {
-		if passthroughFunc(req) {
-			return rec.getRoundTripper().RoundTrip(req)
-		}
-	}
-
 	interaction, err := rec.requestHandler(req, serverResponse)
 	if err != nil {
+		if errors.Is(err, cassette.ErrInteractionNotFound) {
+			rec.reportInteractionNotFound(req)
+		}
 		return nil, err
 	}
 
@@ -605,15 +1198,59 @@ func (rec *Recorder) executeAndRecord(req *http.Request, serverResponse *http.Re
 	case <-req.Context().Done():
 		return nil, req.Context().Err()
 	default:
-		// Apply the duration defined in the interaction
+		// Apply the duration defined in the interaction. When the timing
+		// breakdown is known, the response is returned once the first
+		// byte was received, and the body transfer is simulated
+		// separately.
+		timing := interaction.Response.Timing
+		latency := interaction.Response.Duration
+		if timing.FirstByte > 0 {
+			latency = timing.FirstByte
+		}
+
 		if !rec.skipRequestLatency {
-			<-time.After(interaction.Response.Duration)
+			<-time.After(latency)
+		}
+
+		resp, err := interaction.RenderHTTPResponse(req)
+		if err != nil {
+			return nil, err
+		}
+		// Only the interactions of the cassette of the recorder can be
+		// the parents of the redirect hops recorded into it
+		if rec.cassette.Contains(interaction) {
+			withInteractionID(resp, interaction.ID)
+		}
+
+		if !rec.skipRequestLatency && timing.BodyTransfer > 0 {
+			resp.Body = &delayedBody{
+				ReadCloser: resp.Body,
+				ctx:        req.Context(),
+				delay:      timing.BodyTransfer,
+			}
 		}
 
-		return interaction.GetHTTPResponse()
+		return resp, nil
 	}
 }
 
+// isPassthrough returns true, if the given HTTP request is to be forwarded to
+// the original endpoint without being recorded, either because of the mode of
+// the recorder, or because of the passthrough handler functions.
+func (rec *Recorder) isPassthrough(r *http.Request) bool {
+	if rec.mode == ModePassthrough {
+		return true
+	}
+
+	for _, passthroughFunc := range rec.passthroughs {
+		if passthroughFunc(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Mode returns recorder state
 func (rec *Recorder) Mode() Mode {
 	return rec.mode
@@ -28,16 +28,21 @@ package recorder_test
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
 	"gopkg.in/dnaeon/go-vcr.v4/pkg/recorder"
@@ -1271,3 +1276,1909 @@ func TestDiscardInteractionsOnSave(t *testing.T) {
 		t.Fatalf("expected %d interactions, got %d", wantInteractions, gotInteractions)
 	}
 }
+
+func TestUnusedInteractions(t *testing.T) {
+	tests := []testCase{
+		{
+			method:            http.MethodGet,
+			wantBody:          "GET go-vcr\n",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 11,
+			path:              "/api/v1/foo",
+		},
+		{
+			method:            http.MethodPost,
+			body:              "foo",
+			wantBody:          "POST go-vcr\nfoo",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 15,
+			path:              "/api/v1/bar",
+		},
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_unused_interactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record all interactions first
+	rec, err := recorder.New(cassPath, recorder.WithFailOnUnusedInteractions(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := rec.GetDefaultClient()
+	for _, test := range tests {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Recorded interactions are not considered unused
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("recorder did not stop properly: %s", err)
+	}
+
+	// Replay the first interaction only
+	var gotUnused []*cassette.Interaction
+	handler := func(unused []*cassette.Interaction) {
+		gotUnused = unused
+	}
+	rec, err = recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithFailOnUnusedInteractions(true),
+		recorder.WithUnusedInteractionsHandler(handler),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	if err := tests[0].run(ctx, client, serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	err = rec.Stop()
+	if !errors.Is(err, recorder.ErrUnusedInteractions) {
+		t.Fatalf("expected recorder.ErrUnusedInteractions, got %v", err)
+	}
+
+	if len(gotUnused) != 1 {
+		t.Fatalf("expected 1 unused interaction, got %d", len(gotUnused))
+	}
+
+	if gotUnused[0].Request.URL != serverUrl+tests[1].path {
+		t.Fatalf("unexpected unused interaction: %s", gotUnused[0].Request.URL)
+	}
+
+	// Prune the unused interaction from the cassette
+	rec, err = recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayWithNewEpisodes),
+		recorder.WithPruneUnusedInteractions(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	if err := tests[0].run(ctx, client, serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatalf("recorder did not stop properly: %s", err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 interaction after pruning, got %d", len(c.Interactions))
+	}
+
+	if c.Interactions[0].Request.Method != http.MethodGet {
+		t.Fatalf("unexpected interaction kept in cassette: %s", c.Interactions[0].Request.Method)
+	}
+}
+
+func TestPruneUnusedInteractionsRecordOnce(t *testing.T) {
+	tests := []testCase{
+		{
+			method:            http.MethodGet,
+			wantBody:          "GET go-vcr\n",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 11,
+			path:              "/api/v1/foo",
+		},
+		{
+			method:            http.MethodGet,
+			wantBody:          "GET go-vcr\n",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 11,
+			path:              "/api/v1/bar",
+		},
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_prune_unused_interactions_record_once")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := rec.GetDefaultClient()
+	for _, test := range tests {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The existing cassette should be saved, even though nothing new was
+	// recorded
+	rec, err = recorder.New(cassPath, recorder.WithPruneUnusedInteractions(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tests[0].run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 || c.Interactions[0].Request.URL != serverUrl+tests[0].path {
+		t.Fatalf("expected only the replayed interaction to be kept, got %d interactions", len(c.Interactions))
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	tests := []testCase{
+		{
+			method:            http.MethodGet,
+			wantBody:          "GET go-vcr\n",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 11,
+			path:              "/api/v1/foo",
+		},
+		{
+			method:            http.MethodPost,
+			body:              "foo",
+			wantBody:          "POST go-vcr\nfoo",
+			wantStatus:        http.StatusOK,
+			wantContentLength: 15,
+			path:              "/api/v1/bar",
+		},
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_max_age")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record interactions and make the first one look stale
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := rec.GetDefaultClient()
+	for _, test := range tests {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, i := range c.Interactions {
+		if i.RecordedAt.IsZero() {
+			t.Fatalf("interaction %d has no recorded time", i.ID)
+		}
+	}
+
+	// Interactions without a recorded time have an unknown age, and never
+	// expire
+	staleTime := time.Now().Add(-365 * 24 * time.Hour)
+	c.Interactions[0].RecordedAt = staleTime
+	c.Interactions[1].RecordedAt = time.Time{}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying expired interactions should fail
+	rec, err = recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithMaxAge(30*24*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	if err := tests[0].run(ctx, client, serverUrl); !errors.Is(err, recorder.ErrInteractionExpired) {
+		t.Fatalf("expected recorder.ErrInteractionExpired, got %v", err)
+	}
+
+	if err := tests[1].run(ctx, client, serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired interactions should be refreshed in ModeRecordOnce
+	rec, err = recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeRecordOnce),
+		recorder.WithMaxAge(30*24*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	for _, test := range tests {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != len(tests) || !c.Interactions[0].RecordedAt.After(staleTime) {
+		t.Fatalf("expired interaction was not refreshed")
+	}
+
+	if !c.Interactions[1].RecordedAt.IsZero() {
+		t.Fatalf("interaction without a recorded time was re-recorded")
+	}
+
+	c.Interactions[0].RecordedAt = staleTime
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired interactions should be re-recorded
+	rec, err = recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeReplayWithNewEpisodes),
+		recorder.WithMaxAge(30*24*time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	for _, test := range tests {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != len(tests) {
+		t.Fatalf("expected %d interactions, got %d", len(tests), len(c.Interactions))
+	}
+
+	if !c.Interactions[0].RecordedAt.After(staleTime) {
+		t.Fatalf("expired interaction was not re-recorded")
+	}
+
+	if c.Interactions[0].Request.Method != tests[0].method {
+		t.Fatalf("re-recorded interaction changed its position in the cassette")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]recorder.Mode{
+		"record":       recorder.ModeRecordOnly,
+		"replay":       recorder.ModeReplayOnly,
+		"new_episodes": recorder.ModeReplayWithNewEpisodes,
+		"once":         recorder.ModeRecordOnce,
+		" Passthrough": recorder.ModePassthrough,
+	}
+
+	for name, wantMode := range tests {
+		mode, err := recorder.ParseMode(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != wantMode {
+			t.Fatalf("got mode %s for %q, want mode %s", mode, name, wantMode)
+		}
+	}
+
+	if _, err := recorder.ParseMode("rewind"); !errors.Is(err, recorder.ErrInvalidMode) {
+		t.Fatalf("expected recorder.ErrInvalidMode, got %v", err)
+	}
+}
+
+func TestModeFromEnv(t *testing.T) {
+	cassPath, err := newCassettePath("test_mode_from_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_VCR_MODE", "passthrough")
+	rec, err := recorder.New(
+		cassPath,
+		recorder.WithMode(recorder.ModeRecordOnly),
+		recorder.WithModeFromEnv("TEST_VCR_MODE"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Mode() != recorder.ModePassthrough {
+		t.Fatalf("got mode %s, want mode %s", rec.Mode(), recorder.ModePassthrough)
+	}
+
+	// The global environment variable takes precedence
+	t.Setenv(recorder.ModeEnv, "record")
+	rec, err = recorder.New(cassPath, recorder.WithModeFromEnv("TEST_VCR_MODE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Mode() != recorder.ModeRecordOnly {
+		t.Fatalf("got mode %s, want mode %s", rec.Mode(), recorder.ModeRecordOnly)
+	}
+
+	// The environment is ignored without WithModeFromEnv
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModePassthrough))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Mode() != recorder.ModePassthrough {
+		t.Fatalf("got mode %s, want mode %s", rec.Mode(), recorder.ModePassthrough)
+	}
+
+	t.Setenv(recorder.ModeEnv, "invalid")
+	if _, err := recorder.New(cassPath, recorder.WithModeFromEnv("TEST_VCR_MODE")); !errors.Is(err, recorder.ErrInvalidMode) {
+		t.Fatalf("expected recorder.ErrInvalidMode, got %v", err)
+	}
+
+	if _, err := recorder.New(cassPath); err != nil {
+		t.Fatalf("unexpected error without WithModeFromEnv: %v", err)
+	}
+}
+
+// fakeTB is a [testing.TB], which collects the reported errors instead of
+// failing the test.
+type fakeTB struct {
+	testing.TB
+	name   string
+	errors []string
+}
+
+func (f *fakeTB) Name() string {
+	return f.name
+}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestNewForTest(t *testing.T) {
+	tc := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	// Test cassettes are created relative to the current directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("record sub test", func(t *testing.T) {
+		rec := recorder.NewForTest(t)
+		if err := tc.run(context.Background(), rec.GetDefaultClient(), serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	cassPath := path.Join("testdata", "TestNewForTest", "record_sub_test")
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", len(c.Interactions))
+	}
+
+	if got := c.Interactions[0].Test; got != "TestNewForTest/record_sub_test" {
+		t.Fatalf("want test name %q, got %q", "TestNewForTest/record_sub_test", got)
+	}
+
+	t.Run("replay missing interaction", func(t *testing.T) {
+		tb := &fakeTB{TB: t, name: "TestNewForTest/record sub test"}
+		rec := recorder.NewForTest(tb, recorder.WithMode(recorder.ModeReplayOnly))
+		tc.path = "/api/v1/missing"
+		err := tc.run(context.Background(), rec.GetDefaultClient(), serverUrl)
+		if !errors.Is(err, cassette.ErrInteractionNotFound) {
+			t.Fatalf("expected cassette.ErrInteractionNotFound, got %v", err)
+		}
+
+		if len(tb.errors) != 1 {
+			t.Fatalf("expected 1 reported error, got %d", len(tb.errors))
+		}
+
+		if !strings.Contains(tb.errors[0], "/api/v1/missing") || !strings.Contains(tb.errors[0], "/api/v1/foo") {
+			t.Fatalf("reported error has no diagnostics: %s", tb.errors[0])
+		}
+	})
+}
+
+func TestStrictOrdering(t *testing.T) {
+	fooTest := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	barTest := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/bar",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+
+	otherServer := newEchoHttpServer()
+	otherServerUrl := otherServer.URL
+
+	cassPath, err := newCassettePath("test_strict_ordering")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record interactions with both servers
+	ctx := context.Background()
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := rec.GetDefaultClient()
+	for _, test := range []testCase{fooTest, barTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fooTest.run(ctx, client, otherServerUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	otherServer.Close()
+
+	// Requests out of the recorded sequence should fail
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly), recorder.WithStrictOrdering(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	outOfOrder := barTest
+	outOfOrder.wantError = cassette.ErrInteractionOutOfOrder
+	if err := outOfOrder.run(ctx, client, serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	otherOutOfOrder := fooTest
+	otherOutOfOrder.wantError = cassette.ErrInteractionOutOfOrder
+	if err := otherOutOfOrder.run(ctx, client, otherServerUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []testCase{fooTest, barTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := fooTest.run(ctx, client, otherServerUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests to different hosts are ordered independently of each other
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly), recorder.WithOrderingGroup(cassette.GroupByHost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client = rec.GetDefaultClient()
+	if err := fooTest.run(ctx, client, otherServerUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := outOfOrder.run(ctx, client, serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []testCase{fooTest, barTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStrictOrderingRefresh(t *testing.T) {
+	fooTest := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	barTest := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/bar",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_strict_ordering_refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := rec.GetDefaultClient()
+	for _, test := range []testCase{fooTest, barTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first interaction is replayed twice, and is stale
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Interactions[0].Repeat = 2
+	c.Interactions[0].RecordedAt = time.Now().Add(-365 * 24 * time.Hour)
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The refreshed interaction should keep its place in the sequence
+	opts := []recorder.Option{
+		recorder.WithStrictOrdering(true),
+		recorder.WithMaxAge(30 * 24 * time.Hour),
+	}
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client = rec.GetDefaultClient()
+	for _, test := range []testCase{fooTest, fooTest, barTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 2 || c.Interactions[0].Repeat != 2 {
+		t.Fatalf("unexpected interactions after refresh: %d", len(c.Interactions))
+	}
+}
+
+func TestSharedCassettes(t *testing.T) {
+	authTest := testCase{
+		method:            http.MethodPost,
+		body:              "token",
+		wantBody:          "POST go-vcr\ntoken",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 17,
+		path:              "/api/v1/auth",
+	}
+
+	fooTest := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+
+	sharedPath, err := newCassettePath("test_shared_auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cassPath, err := newCassettePath("test_shared_cassettes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record the shared interactions
+	ctx := context.Background()
+	rec, err := recorder.New(sharedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authTest.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Shared interactions should be replayed, and new ones recorded into
+	// the cassette of the recorder only
+	opts := []recorder.Option{
+		recorder.WithSharedCassettes(sharedPath),
+	}
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := rec.GetDefaultClient()
+	for _, test := range []testCase{authTest, fooTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", len(c.Interactions))
+	}
+
+	if c.Interactions[0].Request.URL != serverUrl+fooTest.path {
+		t.Fatalf("unexpected interaction recorded: %s", c.Interactions[0].Request.URL)
+	}
+
+	shared, err := cassette.Load(sharedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(shared.Interactions) != 1 {
+		t.Fatalf("expected 1 shared interaction, got %d", len(shared.Interactions))
+	}
+
+	// Shared cassettes are not consulted in record-only mode
+	recordOnlyPath, err := newCassettePath("test_shared_cassettes_record_only")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = recorder.New(recordOnlyPath, recorder.WithSharedCassettes(sharedPath), recorder.WithMode(recorder.ModeRecordOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authTest.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(recordOnlyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 || c.Interactions[0].Request.URL != serverUrl+authTest.path {
+		t.Fatalf("expected the auth interaction to be recorded, got %d interactions", len(c.Interactions))
+	}
+
+	// Replay both cassettes without the actual server
+	server.Close()
+	opts = append(opts, recorder.WithMode(recorder.ModeReplayOnly))
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client = rec.GetDefaultClient()
+	for _, test := range []testCase{fooTest, authTest} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Shared cassettes must exist
+	_, err = recorder.New(cassPath, recorder.WithSharedCassettes("missing_shared_cassette"))
+	if !errors.Is(err, cassette.ErrCassetteNotFound) {
+		t.Fatalf("expected cassette.ErrCassetteNotFound, got %v", err)
+	}
+}
+
+func TestResponseTemplates(t *testing.T) {
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+
+	cassPath, err := newCassettePath("test_response_templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record an interaction and turn its response into a template
+	ctx := context.Background()
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordTest := testCase{
+		method:            http.MethodPost,
+		body:              `{"id":"foo"}`,
+		wantBody:          "POST go-vcr\n{\"id\":\"foo\"}",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 24,
+		path:              "/api/v1/items",
+	}
+	if err := recordTest.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Interactions[0].Response.Body = `{"id":{{ json "id" | toJSON }}}`
+	c.Interactions[0].Template = true
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The response should echo the live request, regardless of its body
+	matcher := func(r *http.Request, i cassette.Request) bool {
+		return r.Method == i.Method && r.URL.String() == i.URL
+	}
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly), recorder.WithMatcher(matcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	replayTest := testCase{
+		method:            http.MethodPost,
+		body:              `{"id":"bar \"baz\""}`,
+		wantBody:          `{"id":"bar \"baz\""}`,
+		wantStatus:        http.StatusOK,
+		wantContentLength: 20,
+		path:              "/api/v1/items",
+	}
+	if err := replayTest.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeduplicateInteractions(t *testing.T) {
+	tc := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/health",
+	}
+
+	other := testCase{
+		method:            http.MethodPost,
+		body:              "foo",
+		wantBody:          "POST go-vcr\nfoo",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 15,
+		path:              "/api/v1/foo",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+
+	ctx := context.Background()
+	record := func(t *testing.T, mode recorder.DeduplicateMode) string {
+		cassPath, err := newCassettePath("test_deduplicate_interactions")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec, err := recorder.New(cassPath, recorder.WithDeduplicateInteractions(mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client := rec.GetDefaultClient()
+		for _, test := range []testCase{tc, tc, other, tc} {
+			if err := test.run(ctx, client, serverUrl); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := rec.Stop(); err != nil {
+			t.Fatal(err)
+		}
+
+		return cassPath
+	}
+
+	t.Run("counted", func(t *testing.T) {
+		cassPath := record(t, recorder.DeduplicateCounted)
+		c, err := cassette.Load(cassPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only consecutive interactions are collapsed
+		if len(c.Interactions) != 3 {
+			t.Fatalf("expected 3 interactions, got %d", len(c.Interactions))
+		}
+
+		if c.Interactions[0].Repeat != 2 || c.Interactions[1].Repeat != 0 || c.Interactions[2].Repeat != 0 {
+			t.Fatalf("unexpected repeat counts: %d, %d, %d", c.Interactions[0].Repeat, c.Interactions[1].Repeat, c.Interactions[2].Repeat)
+		}
+
+		// The recorded order is kept, so the interactions can be replayed
+		// in strict order
+		opts := []recorder.Option{
+			recorder.WithMode(recorder.ModeReplayOnly),
+			recorder.WithStrictOrdering(true),
+		}
+		rec, err := recorder.New(cassPath, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rec.Stop()
+
+		client := rec.GetDefaultClient()
+		for _, test := range []testCase{tc, tc, other, tc} {
+			if err := test.run(ctx, client, serverUrl); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tc.run(ctx, client, serverUrl); !errors.Is(err, cassette.ErrInteractionNotFound) {
+			t.Fatalf("expected cassette.ErrInteractionNotFound, got %v", err)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		cassPath := record(t, recorder.DeduplicateUnlimited)
+		c, err := cassette.Load(cassPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(c.Interactions) != 3 || c.Interactions[0].Repeat != cassette.RepeatUnlimited {
+			t.Fatalf("expected 3 interactions with unlimited repeats")
+		}
+
+		rec, err := recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rec.Stop()
+
+		client := rec.GetDefaultClient()
+		for i := 0; i < 10; i++ {
+			if err := tc.run(ctx, client, serverUrl); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestResponseSequences(t *testing.T) {
+	// A polling endpoint, which returns different responses over time
+	var calls int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls += 1
+		if calls < 3 {
+			fmt.Fprint(w, "pending")
+			return
+		}
+		fmt.Fprint(w, "done")
+	})
+	server := httptest.NewServer(handler)
+	serverUrl := server.URL
+
+	cassPath, err := newCassettePath("test_response_sequences")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := testCase{
+		method:            http.MethodGet,
+		wantBody:          "pending",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 7,
+		path:              "/api/v1/status",
+	}
+
+	done := testCase{
+		method:            http.MethodGet,
+		wantBody:          "done",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 4,
+		path:              "/api/v1/status",
+	}
+
+	opts := []recorder.Option{
+		recorder.WithResponseSequences(cassette.SequenceRepeatLast),
+	}
+	rec, err := recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := rec.GetDefaultClient()
+	for _, test := range []testCase{pending, pending, done} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 interaction, got %d", len(c.Interactions))
+	}
+
+	i := c.Interactions[0]
+	if len(i.Sequence) != 3 || i.AfterSequence != cassette.SequenceRepeatLast {
+		t.Fatalf("expected a sequence of 3 responses, got %d", len(i.Sequence))
+	}
+
+	if i.Response.Body != "pending" {
+		t.Fatalf("expected the first response of the sequence as the response, got %q", i.Response.Body)
+	}
+
+	// Replay the sequence without the actual server
+	server.Close()
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	client = rec.GetDefaultClient()
+	for _, test := range []testCase{pending, pending, done, done, done} {
+		if err := test.run(ctx, client, serverUrl); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCassetteMetadata(t *testing.T) {
+	tc := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_cassette_metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata is not updated by default
+	disabledPath, err := newCassettePath("test_cassette_metadata_disabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := recorder.New(disabledPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.run(context.Background(), rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(disabledPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c.Metadata, cassette.Metadata{}) {
+		t.Fatalf("unexpected metadata %+v", c.Metadata)
+	}
+
+	opts := []recorder.Option{
+		recorder.WithMode(recorder.ModeReplayWithNewEpisodes),
+		recorder.WithMetadata(true),
+		recorder.WithLabels(map[string]string{"service": "echo"}),
+	}
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.run(context.Background(), rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := c.Metadata
+	if m.RecordedAt.IsZero() {
+		t.Fatal("recorded time is missing from metadata")
+	}
+
+	if m.Mode != "new_episodes" {
+		t.Fatalf("got mode %q, want mode %q", m.Mode, "new_episodes")
+	}
+
+	if !strings.Contains(m.Matcher, "defaultMatcher") {
+		t.Fatalf("unexpected matcher description %q", m.Matcher)
+	}
+
+	if m.Labels["service"] != "echo" {
+		t.Fatalf("unexpected labels %v", m.Labels)
+	}
+
+	// Metadata should not be updated, when nothing new was recorded
+	recordedAt := m.RecordedAt.Add(-time.Hour)
+	c.Metadata.RecordedAt = recordedAt
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.run(context.Background(), rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Metadata.RecordedAt.Equal(recordedAt) {
+		t.Fatalf("metadata was updated without recording new interactions")
+	}
+}
+
+func TestTimingBreakdown(t *testing.T) {
+	tc := testCase{
+		method:            http.MethodGet,
+		wantBody:          "GET go-vcr\n",
+		wantStatus:        http.StatusOK,
+		wantContentLength: 11,
+		path:              "/api/v1/foo",
+	}
+
+	server := newEchoHttpServer()
+	serverUrl := server.URL
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_timing_breakdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use a new transport, so that a new connection is established
+	opts := []recorder.Option{
+		recorder.WithRealTransport(&http.Transport{}),
+		recorder.WithSkipRequestLatency(true),
+	}
+	rec, err := recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := tc.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timing := c.Interactions[0].Response.Timing
+	if timing.Connect <= 0 || timing.FirstByte <= 0 {
+		t.Fatalf("timing breakdown was not recorded: %+v", timing)
+	}
+
+	// Replay should simulate the time to first byte and the body transfer
+	c.Interactions[0].Response.Timing = cassette.Timing{
+		FirstByte:    20 * time.Millisecond,
+		BodyTransfer: 50 * time.Millisecond,
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	start := time.Now()
+	if err := tc.run(ctx, rec.GetDefaultClient(), serverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Fatalf("replay took %s, expected at least %s", elapsed, 70*time.Millisecond)
+	}
+}
+
+// roundTripperFunc is an [http.RoundTripper] implemented by a function.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTLSConnectionState(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s go-vcr", r.Method)
+	})
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_tls_connection_state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rec *recorder.Recorder) (*http.Response, error) {
+		resp, err := rec.GetDefaultClient().Get(server.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+
+		return resp, err
+	}
+
+	// Capture the connection state of the real response, since the
+	// response returned by the recorder is rebuilt from the cassette
+	var realState *tls.ConnectionState
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := server.Client().Transport.RoundTrip(r)
+		if err == nil {
+			realState = resp.TLS
+		}
+		return resp, err
+	})
+
+	rec, err := recorder.New(cassPath, recorder.WithRealTransport(transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := get(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if realState == nil {
+		t.Fatal("real response has no TLS connection state")
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := c.Interactions[0].Response.TLS
+	if state == nil {
+		t.Fatal("TLS connection state was not recorded")
+	}
+
+	if len(state.PeerCertificates) != 1 {
+		t.Fatalf("want 1 peer certificate, got %d", len(state.PeerCertificates))
+	}
+
+	// Replay should reconstruct the connection state
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	replayed, err := get(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replayed.TLS == nil {
+		t.Fatal("replayed response has no TLS connection state")
+	}
+
+	if replayed.TLS.Version != realState.Version {
+		t.Fatalf("want TLS version %x, got %x", realState.Version, replayed.TLS.Version)
+	}
+
+	if replayed.TLS.CipherSuite != realState.CipherSuite {
+		t.Fatalf("want cipher suite %x, got %x", realState.CipherSuite, replayed.TLS.CipherSuite)
+	}
+
+	if replayed.TLS.NegotiatedProtocol != realState.NegotiatedProtocol {
+		t.Fatalf("want negotiated protocol %q, got %q", realState.NegotiatedProtocol, replayed.TLS.NegotiatedProtocol)
+	}
+
+	if len(replayed.TLS.PeerCertificates) != 1 || !replayed.TLS.PeerCertificates[0].Equal(server.Certificate()) {
+		t.Fatal("replayed peer certificate does not match the certificate of the server")
+	}
+}
+
+func TestRedirectChains(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusFound)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/end", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "end of chain")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_redirect_chains")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(rec *recorder.Recorder, url string) error {
+		resp, err := rec.GetDefaultClient().Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+
+		if string(body) != "end of chain" {
+			return fmt.Errorf("got body: %q, want body: %q", string(body), "end of chain")
+		}
+
+		return nil
+	}
+
+	rec, err := recorder.New(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get(rec, server.URL+"/start?token=first"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chains := c.RedirectChains()
+	if len(chains) != 1 || len(chains[0]) != 3 {
+		t.Fatalf("expected a single redirect chain with 3 hops, got %v", chains)
+	}
+
+	for n, i := range chains[0] {
+		if i.ID != n {
+			t.Fatalf("expected interaction %d at position %d of the chain, got %d", n, n, i.ID)
+		}
+	}
+
+	if c.Interactions[0].ParentID != nil {
+		t.Fatal("initial request should not have a parent")
+	}
+
+	if last := c.Interactions[2]; len(c.RedirectChain(last)) != 3 {
+		t.Fatalf("expected the chain of the last hop to have 3 interactions")
+	}
+
+	// Replay the chain with a different value for the ignored parameter
+	opts := []recorder.Option{
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithMatcher(cassette.NewDefaultMatcher(cassette.WithIgnoreQueryParams("token"))),
+	}
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	if err := get(rec, server.URL+"/start?token=second"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedactCookies(t *testing.T) {
+	var sessions int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		sessions += 1
+		w.Header().Add("Set-Cookie", fmt.Sprintf("session=secret-%d; Path=/; Max-Age=3600; HttpOnly", sessions))
+		w.Header().Add("Set-Cookie", "theme=dark; Path=/")
+		fmt.Fprint(w, "logged in")
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "user with %s", cookie.Value)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cassPath, err := newCassettePath("test_redact_cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := func(rec *recorder.Recorder) (string, error) {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return "", err
+		}
+		client := rec.GetDefaultClient()
+		client.Jar = jar
+
+		for _, p := range []string{"/login", "/me"} {
+			resp, err := client.Get(server.URL + p)
+			if err != nil {
+				return "", err
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return "", err
+			}
+			if p == "/me" {
+				return string(body), nil
+			}
+		}
+
+		return "", nil
+	}
+
+	rules := []recorder.CookieRedactionRule{
+		{Name: "sess*"},
+	}
+	opts := []recorder.Option{
+		recorder.WithHook(recorder.RedactCookies(rules...), recorder.BeforeSaveHook),
+	}
+	rec, err := recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantSetCookie := []string{
+		"session=REDACTED; Path=/; Max-Age=3600; HttpOnly",
+		"theme=dark; Path=/",
+	}
+	gotSetCookie := c.Interactions[0].Response.Headers.Values("Set-Cookie")
+	if strings.Join(gotSetCookie, "\n") != strings.Join(wantSetCookie, "\n") {
+		t.Fatalf("want Set-Cookie headers %q, got %q", wantSetCookie, gotSetCookie)
+	}
+
+	wantCookie := "session=REDACTED; theme=dark"
+	if got := c.Interactions[1].Request.Headers.Get("Cookie"); got != wantCookie {
+		t.Fatalf("want Cookie header %q, got %q", wantCookie, got)
+	}
+
+	// The client sends back the redacted cookie on replay, which matches
+	// the redacted request
+	rec, err = recorder.New(cassPath, recorder.WithMode(recorder.ModeReplayOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	body, err := session(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body != "user with secret-1" {
+		t.Fatalf("unexpected replayed body: %q", body)
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	cassPath, err := newCassettePath("test_http_middleware")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 1)
+	opts := []recorder.Option{
+		recorder.WithMode(recorder.ModeRecordOnly),
+		recorder.WithMiddlewareErrorHandler(func(r *http.Request, err error) {
+			errs <- err
+		}),
+	}
+	rec, err := recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("response writer does not implement http.Flusher")
+		}
+
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Content-Type", "text/plain")
+		for _, chunk := range []string{"first\n", "second\n"} {
+			fmt.Fprint(w, chunk)
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("failed to flush response: %s", err)
+			}
+		}
+		w.Header().Set("X-Checksum", "abc")
+	})
+	mux.HandleFunc("/hijack", func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack connection: %s", err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 204 No Content\r\n\r\n")
+		buf.Flush()
+	})
+
+	server := httptest.NewServer(rec.HTTPMiddleware(mux))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "first\nsecond\n" {
+		t.Fatalf("unexpected body: %q", string(body))
+	}
+
+	resp, err = http.Get(server.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Responses written to a hijacked connection cannot be recorded
+	if err := <-errs; !errors.Is(err, recorder.ErrConnectionHijacked) {
+		t.Fatalf("expected hijacked connection error, got %v", err)
+	}
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", len(c.Interactions))
+	}
+
+	i := c.Interactions[0]
+	if i.Request.URL != "http://go-vcr/stream" {
+		t.Fatalf("want URL %q, got %q", "http://go-vcr/stream", i.Request.URL)
+	}
+
+	if i.Response.Body != "first\nsecond\n" {
+		t.Fatalf("unexpected recorded body: %q", i.Response.Body)
+	}
+
+	if got := i.Response.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Fatalf("want trailer X-Checksum %q, got %q", "abc", got)
+	}
+}
+
+func TestHTTPMiddlewareRequestHost(t *testing.T) {
+	cassPath, err := newCassettePath("test_http_middleware_request_host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := recorder.New(cassPath, recorder.WithMiddlewareRequestHost(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})
+	server := httptest.NewServer(rec.HTTPMiddleware(handler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", len(c.Interactions))
+	}
+
+	if got := c.Interactions[0].Request.URL; got != server.URL+"/api/v1/foo" {
+		t.Fatalf("want URL %q, got %q", server.URL+"/api/v1/foo", got)
+	}
+}
+
+func TestHTTPMiddlewareExistingCassette(t *testing.T) {
+	cassPath, err := newCassettePath("test_http_middleware_existing_cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	})
+
+	// Running against an existing cassette should neither record nor
+	// report errors
+	for _, mode := range []recorder.Mode{recorder.ModeRecordOnce, recorder.ModeRecordOnce, recorder.ModeReplayOnly} {
+		opts := []recorder.Option{
+			recorder.WithMode(mode),
+			recorder.WithMiddlewareErrorHandler(func(r *http.Request, err error) {
+				t.Errorf("unexpected error in mode %s: %s", mode, err)
+			}),
+		}
+		rec, err := recorder.New(cassPath, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server := httptest.NewServer(rec.HTTPMiddleware(handler))
+		resp, err := http.Get(server.URL + "/api/v1/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if err := rec.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := cassette.Load(cassPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.Interactions) != 1 {
+		t.Fatalf("expected 1 recorded interaction, got %d", len(c.Interactions))
+	}
+}
+
+func TestHTTPMiddlewareReplay(t *testing.T) {
+	cassPath, err := newCassettePath("test_http_middleware_replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Handler", "real")
+		w.Header().Set(http.TrailerPrefix+"X-Checksum", "abc")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	})
+
+	post := func(url, body string) (*http.Response, string, error) {
+		resp, err := http.Post(url, "text/plain", strings.NewReader(body))
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+
+		return resp, string(data), err
+	}
+
+	// Record the interaction in front of the real handler
+	rec, err := recorder.New(cassPath, recorder.WithMode(recorder.ModeRecordOnly))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(rec.HTTPMiddleware(handler))
+	if _, _, err := post(server.URL+"/api/v1/foo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying is disabled by default, so the handler is invoked
+	rec, err = recorder.New(cassPath, recorder.WithMatcher(cassette.ServerMatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls.Store(0)
+	server = httptest.NewServer(rec.HTTPMiddleware(handler))
+	if _, _, err := post(server.URL+"/api/v1/foo", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	if calls.Load() != 1 {
+		t.Fatal("handler was not invoked without replaying enabled")
+	}
+
+	// Replay the recorded interaction without invoking the handler. The
+	// stub server listens on a different port, which is ignored by the
+	// server matcher.
+	errs := make(chan error, 1)
+	opts := []recorder.Option{
+		recorder.WithMode(recorder.ModeReplayOnly),
+		recorder.WithMiddlewareReplay(true),
+		recorder.WithMatcher(cassette.ServerMatcher),
+		recorder.WithMiddlewareErrorHandler(func(r *http.Request, err error) {
+			errs <- err
+		}),
+	}
+	rec, err = recorder.New(cassPath, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Stop()
+
+	calls.Store(0)
+	server = httptest.NewServer(rec.HTTPMiddleware(handler))
+	defer server.Close()
+
+	resp, body, err := post(server.URL+"/api/v1/foo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 0 {
+		t.Fatal("handler was invoked for a recorded interaction")
+	}
+
+	if body != "POST /api/v1/foo hello" {
+		t.Fatalf("unexpected replayed body: %q", body)
+	}
+
+	if got := resp.Header.Get("X-Handler"); got != "real" {
+		t.Fatalf("want header X-Handler %q, got %q", "real", got)
+	}
+
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Fatalf("want trailer X-Checksum %q, got %q", "abc", got)
+	}
+
+	// Requests without a recorded interaction are served by the handler
+	_, body, err = post(server.URL+"/api/v1/bar", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls.Load() != 1 || body != "POST /api/v1/bar world" {
+		t.Fatalf("unexpected response for a missing interaction: %q", body)
+	}
+
+	if err := <-errs; !errors.Is(err, cassette.ErrInteractionNotFound) {
+		t.Fatalf("expected interaction not found error, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package recorder
+
+import (
+	"context"
+	"net/http"
+)
+
+// interactionIDKey is the context key, which holds the id of the interaction
+// used to produce a response.
+type interactionIDKey struct{}
+
+// withInteractionID associates the response with the interaction with the
+// given id, so that the interaction can be linked as the parent of a redirect
+// hop, which is caused by the response.
+func withInteractionID(resp *http.Response, id int) {
+	req := resp.Request
+	if req == nil {
+		req = &http.Request{}
+	}
+	resp.Request = req.WithContext(context.WithValue(req.Context(), interactionIDKey{}, id))
+}
+
+// redirectParentID returns the id of the interaction, whose redirect response
+// caused the given request to be made by the client, or nil if the request is
+// not a redirect hop.
+func redirectParentID(r *http.Request) *int {
+	if r.Response == nil || r.Response.Request == nil {
+		return nil
+	}
+
+	id, ok := r.Response.Request.Context().Value(interactionIDKey{}).(int)
+	if !ok {
+		return nil
+	}
+
+	return &id
+}
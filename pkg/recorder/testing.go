@@ -0,0 +1,108 @@
+// Copyright (c) 2015-2024 Marin Atanasov Nikolov <dnaeon@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions
+// are met:
+// 1. Redistributions of source code must retain the above copyright
+//    notice, this list of conditions and the following disclaimer
+//    in this position and unchanged.
+// 2. Redistributions in binary form must reproduce the above copyright
+//    notice, this list of conditions and the following disclaimer in the
+//    documentation and/or other materials provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE AUTHOR(S) ``AS IS'' AND ANY EXPRESS OR
+// IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES
+// OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED.
+// IN NO EVENT SHALL THE AUTHOR(S) BE LIABLE FOR ANY DIRECT, INDIRECT,
+// INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT
+// NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE,
+// DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY
+// THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF
+// THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package recorder
+
+import (
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestCassetteDir is the directory relative to the package being tested, in
+// which [NewForTest] stores the test cassettes.
+const TestCassetteDir = "testdata"
+
+// unsafeCassetteNameChars matches characters, which are replaced when deriving
+// a cassette name from the name of a test.
+var unsafeCassetteNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// NewForTest creates a new [Recorder] for the given test and configures it
+// using the provided options. The cassette name is derived from the name of
+// the test, where each subtest is stored in a separate directory, e.g. the
+// cassette for TestFoo/bar is stored in testdata/TestFoo/bar.yaml.
+//
+// The name of the test is recorded with each new interaction, see
+// [WithTestName].
+//
+// The recorder is stopped when the test and all its subtests complete, and
+// any error returned by [Recorder.Stop] is reported using t.Error. Requests for
+// which no recorded interaction is found fail the test with diagnostics about
+// the request and the cassette.
+func NewForTest(t testing.TB, opts ...Option) *Recorder {
+	t.Helper()
+
+	opts = append([]Option{WithTestName(t.Name())}, opts...)
+	rec, err := New(testCassetteName(t), opts...)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %s", err)
+	}
+	rec.tb = t
+
+	t.Cleanup(func() {
+		if err := rec.Stop(); err != nil {
+			t.Errorf("failed to stop recorder: %s", err)
+		}
+	})
+
+	return rec
+}
+
+// testCassetteName returns the cassette name for the given test.
+func testCassetteName(t testing.TB) string {
+	parts := []string{TestCassetteDir}
+	for _, part := range strings.Split(t.Name(), "/") {
+		part = unsafeCassetteNameChars.ReplaceAllString(part, "_")
+		if part == "" || part == "." || part == ".." {
+			part = "_"
+		}
+		parts = append(parts, part)
+	}
+
+	return filepath.Join(parts...)
+}
+
+// reportInteractionNotFound fails the test associated with the recorder, when
+// no recorded interaction was found for the given HTTP request.
+func (rec *Recorder) reportInteractionNotFound(r *http.Request) {
+	if rec.tb == nil {
+		return
+	}
+
+	remaining := "none"
+	if unused := rec.cassette.UnusedInteractions(); len(unused) > 0 {
+		remaining = describeInteractions(unused)
+	}
+
+	rec.tb.Errorf(
+		"go-vcr: no recorded interaction found for %s %s in cassette %s (mode %s), remaining interactions: %s",
+		r.Method,
+		r.URL.String(),
+		rec.cassette.File,
+		rec.mode,
+		remaining,
+	)
+}
@@ -0,0 +1,109 @@
+package recorder
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"gopkg.in/dnaeon/go-vcr.v4/pkg/cassette"
+)
+
+// timingTrace collects the timing breakdown of a request using
+// [httptrace.ClientTrace].
+type timingTrace struct {
+	sync.Mutex
+
+	start           time.Time
+	dnsStart        time.Time
+	dnsDone         time.Time
+	connectStart    time.Time
+	connectDone     time.Time
+	tlsStart        time.Time
+	tlsDone         time.Time
+	firstByte       time.Time
+	bodyTransferred time.Time
+}
+
+// newTimingTrace creates a new [timingTrace] for a request started at the
+// given time.
+func newTimingTrace(start time.Time) *timingTrace {
+	return &timingTrace{start: start}
+}
+
+// record records the current time into the given field, unless it has already
+// been recorded, e.g. by a concurrent dial.
+func (t *timingTrace) record(field *time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	if field.IsZero() {
+		*field = time.Now()
+	}
+}
+
+// withContext returns a copy of the given context, which traces the request.
+func (t *timingTrace) withContext(ctx context.Context) context.Context {
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.record(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.record(&t.dnsDone) },
+		ConnectStart:         func(string, string) { t.record(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { t.record(&t.connectDone) },
+		TLSHandshakeStart:    func() { t.record(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.record(&t.tlsDone) },
+		GotFirstResponseByte: func() { t.record(&t.firstByte) },
+	}
+
+	return httptrace.WithClientTrace(ctx, trace)
+}
+
+// timing returns the collected timing breakdown.
+func (t *timingTrace) timing() cassette.Timing {
+	t.Lock()
+	defer t.Unlock()
+
+	since := func(from, to time.Time) time.Duration {
+		if from.IsZero() || to.IsZero() {
+			return 0
+		}
+		return to.Sub(from)
+	}
+
+	timing := cassette.Timing{
+		DNSLookup:    since(t.dnsStart, t.dnsDone),
+		Connect:      since(t.connectStart, t.connectDone),
+		TLSHandshake: since(t.tlsStart, t.tlsDone),
+		FirstByte:    since(t.start, t.firstByte),
+		BodyTransfer: since(t.firstByte, t.bodyTransferred),
+	}
+
+	return timing
+}
+
+// delayedBody simulates the transfer time of a response body by delaying the
+// first read from it.
+type delayedBody struct {
+	io.ReadCloser
+
+	ctx   context.Context
+	delay time.Duration
+	once  sync.Once
+}
+
+// Read implements the [io.Reader] interface.
+func (b *delayedBody) Read(p []byte) (int, error) {
+	var err error
+	b.once.Do(func() {
+		select {
+		case <-b.ctx.Done():
+			err = b.ctx.Err()
+		case <-time.After(b.delay):
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return b.ReadCloser.Read(p)
+}